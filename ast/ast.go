@@ -11,6 +11,10 @@ type Node interface {
 	TokenLiteral() string
 	// String every node to source code
 	String() string
+	// Pos is the position of the first character of the node
+	Pos() token.Position
+	// End is the position immediately after the node
+	End() token.Position
 }
 
 type Statement interface {
@@ -35,6 +39,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var buf bytes.Buffer
 	for _, s := range p.Statements {
@@ -52,6 +70,8 @@ type Identifier struct {
 // in this situation. so
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -64,6 +84,13 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Name.End()
+}
 func (ls *LetStatement) String() string {
 	var buf bytes.Buffer
 	buf.WriteString(ls.TokenLiteral() + " ")
@@ -84,6 +111,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	var buf bytes.Buffer
 	buf.WriteString(rs.TokenLiteral() + " ")
@@ -101,6 +135,13 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -116,6 +157,19 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, e.g. !
@@ -126,6 +180,8 @@ type PrefixExpression struct {
 // !true, +1, -1
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position  { return pe.Right.End() }
 func (pe *PrefixExpression) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(")
@@ -145,6 +201,8 @@ type InfixExpression struct {
 // 1+1, 1*1
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Position  { return ie.Right.End() }
 func (ie *InfixExpression) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(")
@@ -155,6 +213,29 @@ func (ie *InfixExpression) String() string {
 	return buf.String()
 }
 
+// AssignExpression rebinds an identifier or stores into an index
+// expression: x = 1, a[i] += 2.
+type AssignExpression struct {
+	Token    token.Token // The assignment operator token
+	Target   Expression  // *Identifier or *IndexExpression
+	Operator string      // =, +=, -=, *= or /=
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) End() token.Position  { return ae.Value.End() }
+func (ae *AssignExpression) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	buf.WriteString(ae.Target.String())
+	buf.WriteString(" " + ae.Operator + " ")
+	buf.WriteString(ae.Value.String())
+	buf.WriteString(")")
+	return buf.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -163,6 +244,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type IfExpression struct {
 	Token       token.Token // The 'if' token
@@ -173,6 +256,13 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("if")
@@ -189,10 +279,13 @@ func (ie *IfExpression) String() string {
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
+	EndToken   token.Token // the } token
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position  { return bs.EndToken.End }
 func (bs *BlockStatement) String() string {
 	var buf bytes.Buffer
 	for _, b := range bs.Statements {
@@ -201,14 +294,138 @@ func (bs *BlockStatement) String() string {
 	return buf.String()
 }
 
+type WhileStatement struct {
+	Token     token.Token // The 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("while")
+	buf.WriteString(ws.Condition.String())
+	buf.WriteString(" ")
+	buf.WriteString(ws.Body.String())
+	return buf.String()
+}
+
+// ForStatement is `for (init; condition; post) { body }`. Each of the
+// clauses may be left out; a missing condition is always true.
+type ForStatement struct {
+	Token     token.Token // The 'for' token
+	Init      Statement
+	Condition Expression
+	Post      Statement
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("for (")
+	if fs.Init != nil {
+		buf.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+	}
+	buf.WriteString("; ")
+	if fs.Condition != nil {
+		buf.WriteString(fs.Condition.String())
+	}
+	buf.WriteString("; ")
+	if fs.Post != nil {
+		buf.WriteString(strings.TrimSuffix(fs.Post.String(), ";"))
+	}
+	buf.WriteString(") ")
+	buf.WriteString(fs.Body.String())
+	return buf.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // The 'break' token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+
+type ContinueStatement struct {
+	Token token.Token // The 'continue' token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+
+type ThrowStatement struct {
+	Token token.Token // The 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) End() token.Position  { return ts.Value.End() }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+// TryStatement is `try { body } catch (param) { catch } finally { finally }`.
+// Either the catch or the finally clause may be left out, but not both.
+type TryStatement struct {
+	Token   token.Token // The 'try' token
+	Body    *BlockStatement
+	Param   *Identifier // nil without a catch clause
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *TryStatement) End() token.Position {
+	if ts.Finally != nil {
+		return ts.Finally.End()
+	}
+	return ts.Catch.End()
+}
+func (ts *TryStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("try ")
+	buf.WriteString(ts.Body.String())
+	if ts.Catch != nil {
+		buf.WriteString(" catch (")
+		buf.WriteString(ts.Param.String())
+		buf.WriteString(") ")
+		buf.WriteString(ts.Catch.String())
+	}
+	if ts.Finally != nil {
+		buf.WriteString(" finally ")
+		buf.WriteString(ts.Finally.String())
+	}
+	return buf.String()
+}
+
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Name       string // the binding of `let name = fn...`, if any
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position  { return fl.Body.End() }
 func (fl *FunctionLiteral) String() string {
 	var buf bytes.Buffer
 	params := []string{}
@@ -228,10 +445,13 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	EndToken  token.Token // The ')' token
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return ce.EndToken.End }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -253,14 +473,46 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+
+// TemplateLiteral is a string with expressions embedded in it, such as
+// "a ${x} b". Strings holds the text around the Expressions, so it has one
+// more element than them.
+type TemplateLiteral struct {
+	Token       token.Token // the TEMPLATE_HEAD token
+	Strings     []string
+	Expressions []Expression
+	EndToken    token.Token // the TEMPLATE_TAIL token
+}
+
+func (tl *TemplateLiteral) expressionNode()      {}
+func (tl *TemplateLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TemplateLiteral) Pos() token.Position  { return tl.Token.Pos }
+func (tl *TemplateLiteral) End() token.Position  { return tl.EndToken.End }
+func (tl *TemplateLiteral) String() string {
+	var out bytes.Buffer
+	out.WriteString(`"`)
+	for i, s := range tl.Strings {
+		out.WriteString(s)
+		if i < len(tl.Expressions) {
+			out.WriteString("${" + tl.Expressions[i].String() + "}")
+		}
+	}
+	out.WriteString(`"`)
+	return out.String()
+}
 
 type ArrayLiteral struct {
 	Token    token.Token // the '[' token
 	Elements []Expression
+	EndToken token.Token // the ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return al.EndToken.End }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -274,13 +526,16 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token // The [ token
-	Left  Expression
-	Index Expression
+	Token    token.Token // The [ token
+	Left     Expression
+	Index    Expression
+	EndToken token.Token // The ] token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return ie.EndToken.End }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -292,17 +547,26 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Token    token.Token // the '{' token
+	Pairs    []HashPair  // in source order
+	EndToken token.Token // the '}' token
+}
+
+// HashPair is a key: value pair of a HashLiteral.
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position  { return hl.EndToken.End }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -315,13 +579,22 @@ var _ Statement = &LetStatement{}
 var _ Statement = &ReturnStatement{}
 var _ Statement = &ExpressionStatement{}
 var _ Statement = &BlockStatement{}
+var _ Expression = &AssignExpression{}
+var _ Statement = &WhileStatement{}
+var _ Statement = &ForStatement{}
+var _ Statement = &BreakStatement{}
+var _ Statement = &ContinueStatement{}
+var _ Statement = &ThrowStatement{}
+var _ Statement = &TryStatement{}
 var _ Expression = &Identifier{}
 var _ Expression = &IntegerLiteral{}
+var _ Expression = &FloatLiteral{}
 var _ Expression = &Boolean{}
 var _ Expression = &IfExpression{}
 var _ Expression = &FunctionLiteral{}
 var _ Expression = &CallExpression{}
 var _ Expression = &StringLiteral{}
+var _ Expression = &TemplateLiteral{}
 var _ Expression = &ArrayLiteral{}
 var _ Expression = &IndexExpression{}
 var _ Expression = &HashLiteral{}
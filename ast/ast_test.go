@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 
 	"example.com/m/token"
@@ -26,3 +27,44 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestFprint(t *testing.T) {
+	pos := func(line, column int) token.Position {
+		return token.Position{Line: line, Column: column, Offset: column - 1}
+	}
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: pos(1, 1)},
+				Name: &Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x", Pos: pos(1, 5)},
+					Value: "x",
+				},
+				Value: &PrefixExpression{
+					Token:    token.Token{Type: token.MINUS, Literal: "-", Pos: pos(1, 9)},
+					Operator: "-",
+					Right: &IntegerLiteral{
+						Token: token.Token{Type: token.INT, Literal: "1", Pos: pos(1, 10)},
+						Value: 1,
+					},
+				},
+			},
+		},
+	}
+	expected := `Program 1:1
+  Statements[0]: LetStatement 1:1
+    Name: Identifier 1:5
+      Value: "x"
+    Value: PrefixExpression 1:9
+      Operator: "-"
+      Right: IntegerLiteral 1:10
+        Value: 1
+`
+	var out strings.Builder
+	if err := Fprint(&out, program); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong tree. expected=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,74 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"example.com/m/token"
+)
+
+var tokenType = reflect.TypeOf(token.Token{})
+
+// Fprint writes the tree of node to w: a line per node, with its type and
+// position, followed by its fields indented one level deeper. Tokens are
+// left out, as are nil nodes and empty values.
+func Fprint(w io.Writer, node Node) error {
+	p := &printer{w: w}
+	p.node(reflect.ValueOf(node), 0)
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(depth int, format string, a ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, strings.Repeat("  ", depth)+format, a...)
+}
+
+// node prints v, a pointer to a node, on the current line.
+func (p *printer) node(v reflect.Value, depth int) {
+	p.printf(0, "%s %s\n", v.Elem().Type().Name(), v.Interface().(Node).Pos())
+	s := v.Elem()
+	for i := 0; i < s.NumField(); i++ {
+		if s.Type().Field(i).Type == tokenType {
+			continue
+		}
+		p.field(s.Type().Field(i).Name, s.Field(i), depth+1)
+	}
+}
+
+func (p *printer) field(name string, v reflect.Value, depth int) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		p.printf(depth, "%s: ", name)
+		p.node(v, depth)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			p.field(fmt.Sprintf("%s[%d]", name, i), v.Index(i), depth)
+		}
+	case reflect.Struct:
+		// the pairs of a hash literal
+		for i := 0; i < v.NumField(); i++ {
+			p.field(name+"."+v.Type().Field(i).Name, v.Field(i), depth)
+		}
+	case reflect.String:
+		if v.String() != "" {
+			p.printf(depth, "%s: %q\n", name, v.String())
+		}
+	default:
+		p.printf(depth, "%s: %v\n", name, v.Interface())
+	}
+}
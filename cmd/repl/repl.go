@@ -1,14 +1,61 @@
+// Command repl runs Monkey programs.
+//
+//	repl [-engine eval|vm]                        start the REPL
+//	repl [-engine eval|vm] run file.mk [args...]  run the script in file.mk
+//	repl [-engine eval|vm] run - [args...]        run the script read from stdin
+//	repl [-engine eval|vm] -e 'expr' [args...]    run expr and print its value
+//
+// A script finds its arguments in the global `args`, an array of strings.
+// The exit status is 1 if the script has a syntax or runtime error, and 2 if
+// the command line is wrong.
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 
+	"example.com/m/object"
 	"example.com/m/repl"
 )
 
 func main() {
+	engine := flag.String("engine", repl.BackendEval, "backend to run programs on: eval or vm")
+	expr := flag.String("e", "", "run `expr` and print its value")
+	flag.Usage = usage
+	flag.Parse()
+
+	backend, err := repl.NewBackend(*engine)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch {
+	case *expr != "":
+		os.Exit(run(backend, "", *expr, flag.Args(), true))
+	case flag.NArg() > 0 && flag.Arg(0) == "run":
+		if flag.NArg() < 2 {
+			usage()
+			os.Exit(2)
+		}
+		source, err := readScript(flag.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		filename := flag.Arg(1)
+		if filename == "-" {
+			filename = ""
+		}
+		os.Exit(run(backend, filename, source, flag.Args()[2:], false))
+	case flag.NArg() > 0:
+		usage()
+		os.Exit(2)
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -19,5 +66,47 @@ func main() {
 		user.Username,
 	)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, backend)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage:
+  %[1]s [flags]                       start the REPL
+  %[1]s [flags] run file.mk [args...] run the script in file.mk, or stdin if file.mk is -
+  %[1]s [flags] -e 'expr' [args...]   run expr and print its value
+flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// readScript reads the script in file, or stdin if file is "-".
+func readScript(file string) (string, error) {
+	var src []byte
+	var err error
+	if file == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(file)
+	}
+	return string(src), err
+}
+
+// run runs source, read from filename if it is not empty, with args bound to
+// `args` and returns the exit status. If print is set, the value of source
+// is printed unless it is null.
+func run(backend repl.Backend, filename, source string, args []string, print bool) int {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	backend.SetGlobal("args", &object.Array{Elements: elements})
+
+	evaluated, ok := repl.Exec(filename, source, backend, os.Stderr)
+	if !ok {
+		return 1
+	}
+	if print && evaluated != nil && evaluated.Type() != object.NULL_OBJ {
+		io.WriteString(os.Stdout, evaluated.Inspect()+"\n")
+	}
+	return 0
 }
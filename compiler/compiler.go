@@ -0,0 +1,925 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/m/ast"
+	"example.com/m/code"
+	"example.com/m/object"
+	"example.com/m/token"
+)
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type CompilationScope struct {
+	instructions        code.Instructions
+	positions           code.PositionTable
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	// loops holds the loops being compiled, innermost last.
+	loops []*loopJumps
+	// tries holds the parts of try statements being compiled, innermost
+	// last, that a return, break or continue has to leave.
+	tries []tryBlock
+}
+
+// loopJumps collects the jumps of the break and continue statements of a
+// loop, which are patched once the loop is compiled.
+type loopJumps struct {
+	breaks    []int
+	continues []int
+	tries     int // the number of tries around the loop
+}
+
+// tryBlock is the body or catch clause of a try statement. Leaving it ends
+// its handler, if it has one, and runs the finally clause.
+type tryBlock struct {
+	handler bool
+	finally *ast.BlockStatement
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	numTries int // try statements compiled, to name their hidden variables
+
+	pos token.Position // the position of the node being compiled
+	err error          // the first operand too large for its instruction
+}
+
+// Bytecode is the output of the compiler and the input of the vm.
+type Bytecode struct {
+	Instructions code.Instructions
+	Positions    code.PositionTable
+	Constants    []object.Object
+	// GlobalNames maps a global slot to its name, for runtime error messages.
+	GlobalNames []string
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpLessEqual,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+var prefixOperators = map[string]code.Opcode{
+	"!": code.OpBang,
+	"-": code.OpMinus,
+	"~": code.OpBitNot,
+}
+
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions: code.Instructions{},
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a compiler that continues from the symbol table and
+// constants of an earlier compilation, as the REPL does between inputs.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// Compile compiles node. Each instruction is recorded with the position of
+// the innermost node it was emitted for, which the vm reports errors at.
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	defer func(pos token.Position) {
+		c.pos = pos
+		if err == nil {
+			err = c.err
+		}
+	}(c.pos)
+	c.pos = node.Pos()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.defineSymbol(node.Name.Value)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.leaveTries(0); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break is not in a loop", node.Pos())
+		}
+		if err := c.leaveTries(loop.tries); err != nil {
+			return err
+		}
+		c.emit(code.OpUnwindLoop)
+		loop.breaks = append(loop.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue is not in a loop", node.Pos())
+		}
+		if err := c.leaveTries(loop.tries); err != nil {
+			return err
+		}
+		c.emit(code.OpUnwindLoop)
+		loop.continues = append(loop.continues, c.emit(code.OpJump, 9999))
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.TryStatement:
+		return c.compileTryStatement(node)
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := prefixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		c.emit(op)
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		c.emit(op)
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.TemplateLiteral:
+		// the strings go on the stack as constants, but for empty ones
+		parts := 0
+		for i, s := range node.Strings {
+			if s != "" {
+				c.emit(code.OpConstant, c.addConstant(&object.String{Value: s}))
+				parts++
+			}
+			if i < len(node.Expressions) {
+				if err := c.Compile(node.Expressions[i]); err != nil {
+					return err
+				}
+				parts++
+			}
+		}
+		c.emit(code.OpTemplate, parts)
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// a global that is bound later, e.g. by mutually recursive
+			// functions; the vm reports it if it is still unbound when read.
+			symbol = c.symbolTable.Global().Define(node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("%s: cannot compile %T", node.Pos(), node)
+	}
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	// Emit an `OpJumpNotTruthy` with a bogus value
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+	// Emit an `OpJump` with a bogus value
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileLogicalExpression compiles && and || so that the right operand is
+// skipped when the left one decides the result. `!!x` turns the deciding
+// operand into a boolean.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "&&" {
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpBang)
+		c.emit(code.OpBang)
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpFalse)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	c.emit(code.OpTrue)
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileAssignExpression compiles an assignment so that it leaves the value
+// assigned on the stack. A compound assignment loads the target before the
+// value is compiled, the way the evaluator orders them.
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	op, compound := infixOperators[strings.TrimSuffix(node.Operator, "=")]
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			// as for a read, the global may be bound by the time this runs
+			symbol = c.symbolTable.Global().Define(target.Value)
+		}
+		switch symbol.Scope {
+		case BuiltinScope:
+			return fmt.Errorf("%s: cannot assign to builtin: %s", node.Pos(), target.Value)
+		case FunctionScope:
+			return fmt.Errorf("%s: cannot assign to %s inside its own body", node.Pos(), target.Value)
+		}
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.emit(code.OpSetIndex)
+
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	c.emit(code.OpLoop)
+	start := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.enterLoop()
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	end := c.emit(code.OpEndLoop)
+	c.changeOperand(exitPos, end)
+	c.leaveLoop(start, end)
+	return nil
+}
+
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if node.Init != nil {
+		if err := c.Compile(node.Init); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpLoop)
+	start := len(c.currentInstructions())
+	exitPos := -1
+	if node.Condition != nil {
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exitPos = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	c.enterLoop()
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	next := len(c.currentInstructions())
+	if node.Post != nil {
+		// a continue in the post statement goes on to the condition
+		loop := c.currentLoop()
+		continues := len(loop.continues)
+		if err := c.Compile(node.Post); err != nil {
+			return err
+		}
+		for _, pos := range loop.continues[continues:] {
+			c.changeOperand(pos, start)
+		}
+		loop.continues = loop.continues[:continues]
+	}
+	c.emit(code.OpJump, start)
+
+	end := c.emit(code.OpEndLoop)
+	if exitPos >= 0 {
+		c.changeOperand(exitPos, end)
+	}
+	c.leaveLoop(next, end)
+	return nil
+}
+
+func (c *Compiler) enterLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loopJumps{tries: len(scope.tries)})
+}
+
+// leaveLoop points the continue statements of the innermost loop at next
+// and its break statements at end.
+func (c *Compiler) leaveLoop(next, end int) {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range loop.continues {
+		c.changeOperand(pos, next)
+	}
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, end)
+	}
+}
+
+func (c *Compiler) currentLoop() *loopJumps {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// compileTryStatement lays out a try statement as
+//
+//	OpTry catch; body; OpEndTry; finally; OpJump end
+//	catch:   OpTryFinally rethrow; set param; catch; OpEndTry; finally; OpJump end
+//	rethrow: set error; finally; get error; OpThrow
+//	end:
+//
+// leaving out the parts of a missing catch or finally clause; without a
+// catch clause, the body starts with OpTryFinally rethrow. The finally
+// clause is compiled again wherever a return, break or continue leaves the
+// statement.
+func (c *Compiler) compileTryStatement(node *ast.TryStatement) error {
+	var jumps []int
+	var handlerPos int
+	if node.Catch != nil {
+		handlerPos = c.emit(code.OpTry, 9999)
+	} else {
+		handlerPos = c.emit(code.OpTryFinally, 9999)
+	}
+	if err := c.compileTryBlock(node.Body, node.Finally); err != nil {
+		return err
+	}
+	jumps = append(jumps, c.emit(code.OpJump, 9999))
+	c.changeOperand(handlerPos, len(c.currentInstructions()))
+
+	if node.Catch != nil {
+		if node.Finally != nil {
+			handlerPos = c.emit(code.OpTryFinally, 9999)
+		}
+		c.defineSymbol(node.Param.Value)
+		if node.Finally != nil {
+			if err := c.compileTryBlock(node.Catch, node.Finally); err != nil {
+				return err
+			}
+			jumps = append(jumps, c.emit(code.OpJump, 9999))
+			c.changeOperand(handlerPos, len(c.currentInstructions()))
+		} else {
+			if err := c.Compile(node.Catch); err != nil {
+				return err
+			}
+			jumps = append(jumps, c.emit(code.OpJump, 9999))
+		}
+	}
+
+	if node.Finally != nil {
+		// the error is kept in a variable of its own rather than on the
+		// stack, which a break or continue in the finally clause would
+		// leave unbalanced
+		symbol := c.defineSymbol(fmt.Sprintf("(try %d)", c.numTries))
+		c.numTries++
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		c.loadSymbol(symbol)
+		c.emit(code.OpThrow)
+	}
+
+	end := len(c.currentInstructions())
+	for _, pos := range jumps {
+		c.changeOperand(pos, end)
+	}
+	return nil
+}
+
+// compileTryBlock compiles the body or catch clause of a try statement,
+// between OpTry and OpEndTry, followed by the finally clause.
+func (c *Compiler) compileTryBlock(block, finally *ast.BlockStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, tryBlock{handler: true, finally: finally})
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+
+	c.emit(code.OpEndTry)
+	if finally != nil {
+		return c.Compile(finally)
+	}
+	return nil
+}
+
+// leaveTries emits what a return, break or continue does before it leaves
+// the try statements of the current function being compiled, innermost
+// first, down to depth: it ends their handlers and runs their finally
+// clauses.
+func (c *Compiler) leaveTries(depth int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	for i := len(tries) - 1; i >= depth; i-- {
+		if tries[i].handler {
+			c.emit(code.OpEndTry)
+		}
+		if tries[i].finally == nil {
+			continue
+		}
+		// the finally clause runs outside its try statement
+		c.scopes[c.scopeIndex].tries = tries[:i:i]
+		err := c.Compile(tries[i].finally)
+		c.scopes[c.scopeIndex].tries = tries
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileBlockValue compiles a block that is used as an expression, leaving
+// the value of its last expression statement, or null, on the stack.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+	markTailCalls(instructions)
+
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		Positions:     positions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Name:          node.Name,
+	}
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	return nil
+}
+
+// markTailCalls turns the calls of a function whose value the function
+// returns as is into tail calls: those followed by OpReturnValue, directly or
+// through jumps, as the call in a return statement or in the last expression
+// of the body is. Such a call is never inside a try block, as a return
+// leaving one ends its handler first.
+func markTailCalls(ins code.Instructions) {
+	for i := 0; i < len(ins); {
+		op := code.Opcode(ins[i])
+		def, _ := code.Lookup(ins[i])
+		_, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+		if op == code.OpCall && returnsAt(ins, next) {
+			ins[i] = byte(code.OpTailCall)
+		}
+		i = next
+	}
+}
+
+// returnsAt reports whether the instructions at pos go straight to
+// OpReturnValue. Jumps are followed no further than there are instructions,
+// as the jump of an empty loop leads back to itself.
+func returnsAt(ins code.Instructions, pos int) bool {
+	for n := 0; n < len(ins) && pos < len(ins) && code.Opcode(ins[pos]) == code.OpJump; n++ {
+		pos = int(code.ReadUint16(ins[pos+1:]))
+	}
+	return pos < len(ins) && code.Opcode(ins[pos]) == code.OpReturnValue
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Constants:    c.constants,
+		GlobalNames:  c.symbolTable.GlobalNames(),
+	}
+}
+
+// SymbolTable returns the table holding the bindings compiled so far.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	c.addPosition(posNewInstruction)
+	return posNewInstruction
+}
+
+// addPosition records that the instructions from offset on are compiled
+// from the node at c.pos. It drops the positions of instructions from
+// offset on that were removed.
+func (c *Compiler) addPosition(offset int) {
+	positions := c.scopes[c.scopeIndex].positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= offset {
+		positions = positions[:len(positions)-1]
+	}
+	if len(positions) == 0 || positions[len(positions)-1].Pos != c.pos {
+		positions = append(positions, code.Position{Offset: offset, Pos: c.pos})
+	}
+	c.scopes[c.scopeIndex].positions = positions
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
+	newInstruction := code.Make(op, operand)
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+// checkOperands records an error if an operand does not fit in the bytes
+// op has for it, which code.Make would silently cut short.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+	for i, operand := range operands {
+		max := 1<<(8*def.OperandWidths[i]) - 1
+		if operand <= max {
+			continue
+		}
+		switch {
+		case op == code.OpConstant || op == code.OpClosure && i == 0:
+			c.err = fmt.Errorf("%s: too many constants (more than %d)", c.pos, max+1)
+		case op == code.OpGetGlobal || op == code.OpSetGlobal || op == code.OpAssignGlobal:
+			c.err = fmt.Errorf("%s: too many global bindings (more than %d)", c.pos, max+1)
+		case op == code.OpGetLocal || op == code.OpSetLocal || op == code.OpCaptureLocal:
+			c.err = fmt.Errorf("%s: too many local bindings (more than %d)", c.pos, max+1)
+		case op == code.OpGetFree || op == code.OpSetFree || op == code.OpCaptureFree:
+			c.err = fmt.Errorf("%s: too many free variables (more than %d)", c.pos, max+1)
+		case op == code.OpClosure:
+			c.err = fmt.Errorf("%s: too many free variables (more than %d)", c.pos, max)
+		case op == code.OpCall || op == code.OpTailCall:
+			c.err = fmt.Errorf("%s: too many arguments (more than %d)", c.pos, max)
+		case op == code.OpArray || op == code.OpHash || op == code.OpTemplate:
+			c.err = fmt.Errorf("%s: too many elements (more than %d)", c.pos, max)
+		default:
+			c.err = fmt.Errorf("%s: too much code in one function (more than %d bytes)", c.pos, max+1)
+		}
+		return
+	}
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return instructions
+}
+
+// defineSymbol binds name in the current scope to the value on top of the
+// stack, as a let statement does.
+func (c *Compiler) defineSymbol(name string) Symbol {
+	symbol := c.symbolTable.Define(name)
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+	return symbol
+}
+
+// storeSymbol assigns the top of the stack to an existing binding.
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpAssignGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol pushes what a closure captures of a free variable: the cell
+// of the variable, so that assignments are shared, or the enclosing closure
+// itself.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
@@ -1,112 +1,435 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"math/big"
+	"strings"
 
 	"example.com/m/ast"
 	"example.com/m/object"
+	"example.com/m/token"
 )
 
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	breakSignal    = &object.Break{}
+	continueSignal = &object.Continue{}
 )
 
+// DefaultMaxDepth bounds the nesting of function calls unless Limits say
+// otherwise, so that runaway recursion is reported as an error before it
+// exhausts the Go stack.
+const DefaultMaxDepth = 10000
+
+// ctxCheckInterval is the number of steps between checks of the context,
+// which keeps the checks cheap.
+const ctxCheckInterval = 256
+
+// Limits bound a run of an Evaluator. Exceeding one of them, or the
+// cancellation of the run's context, fails the run with an error of kind
+// object.LimitError.
+type Limits struct {
+	// MaxSteps bounds the number of AST nodes evaluated. Zero means no
+	// bound.
+	MaxSteps int64
+	// MaxDepth bounds the nesting of function calls, not counting calls in
+	// tail position. Zero means DefaultMaxDepth. Each level uses Go stack,
+	// so a run with a very large depth can exhaust it.
+	MaxDepth int
+	// MaxMemory bounds the estimated memory, in bytes, used by the strings,
+	// arrays and hashes the run can still reach. Zero means no bound. Going
+	// over it raises an error of kind object.ResourceError instead. The
+	// estimate is only recounted now and then, so a run can briefly use up
+	// to twice the bound.
+	MaxMemory int64
+}
+
+// Evaluator walks the AST. It carries the state of a run, such as the call
+// depth, so it must not be shared between goroutines.
+type Evaluator struct {
+	builtins map[string]*object.Builtin
+	limits   Limits
+
+	// state of the current run
+	running  bool
+	ctx      context.Context
+	depth    int
+	runDepth int // the depth the current run started at
+	tryDepth int // try statements being run by the current call
+	steps    int64
+	envs     []*object.Environment // environments of the active calls
+	calls    []object.StackFrame   // the active calls, outermost first
+	temps    []object.Object       // values held by the expressions being evaluated
+	memory   int64                 // estimate of the memory in use
+	live     int64                 // the memory in use at the last recount
+}
+
+func New() *Evaluator {
+	e := &Evaluator{
+		builtins: make(map[string]*object.Builtin, len(builtins)),
+		ctx:      context.Background(),
+	}
+	for name, builtin := range builtins {
+		e.builtins[name] = builtin
+	}
+	return e
+}
+
+// SetLimits sets the limits of the following runs.
+func (e *Evaluator) SetLimits(limits Limits) {
+	e.limits = limits
+}
+
+// RegisterBuiltin makes builtin callable by name from the programs this
+// Evaluator runs, replacing a builtin of the same name. Other Evaluators are
+// not affected.
+func (e *Evaluator) RegisterBuiltin(name string, builtin *object.Builtin) {
+	e.builtins[name] = builtin
+}
+
+// Eval evaluates node in env with a new Evaluator.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+// Eval evaluates node in env. It never panics: a panic inside the evaluator
+// or a builtin is returned as an *object.Error.
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	return e.EvalContext(context.Background(), node, env)
+}
+
+// EvalContext is like Eval, but stops with an error when ctx is done.
+func (e *Evaluator) EvalContext(ctx context.Context, node ast.Node, env *object.Environment) (result object.Object) {
+	defer e.start(ctx)()
+	defer e.recoverPanic(&result)
+	e.envs = append(e.envs, env)
+	return e.eval(node, env)
+}
+
+// Apply calls fn, a function or builtin, with args and returns its result.
+// Like Eval it never panics.
+func (e *Evaluator) Apply(fn object.Object, args []object.Object) object.Object {
+	return e.ApplyContext(context.Background(), fn, args)
+}
+
+// ApplyContext is like Apply, but stops with an error when ctx is done.
+func (e *Evaluator) ApplyContext(ctx context.Context, fn object.Object, args []object.Object) (result object.Object) {
+	defer e.start(ctx)()
+	defer e.recoverPanic(&result)
+	return e.applyFunction(fn, args, token.Position{})
+}
+
+// start begins a run with a fresh budget and returns the function that ends
+// it. A run started during another one, by a builtin calling back into the
+// Evaluator, is part of the outer run: it shares its budget and adds to its
+// calls, which are as they were once it ends.
+func (e *Evaluator) start(ctx context.Context) (end func()) {
+	if e.running {
+		outerCtx, depth, runDepth, tryDepth := e.ctx, e.depth, e.runDepth, e.tryDepth
+		envs, calls, temps := len(e.envs), len(e.calls), len(e.temps)
+		e.ctx = ctx
+		e.runDepth = e.depth
+		e.tryDepth = 0
+		return func() {
+			e.ctx, e.depth, e.runDepth, e.tryDepth = outerCtx, depth, runDepth, tryDepth
+			e.envs, e.calls, e.temps = e.envs[:envs], e.calls[:calls], e.temps[:temps]
+		}
+	}
+	e.running = true
+	e.ctx = ctx
+	e.depth = 0
+	e.runDepth = 0
+	e.tryDepth = 0
+	e.steps = 0
+	e.envs = e.envs[:0]
+	e.calls = e.calls[:0]
+	e.temps = e.temps[:0]
+	e.memory = 0
+	e.live = 0
+	return func() { e.running = false }
+}
+
+// step accounts for the evaluation of one node and reports a limit error
+// once the run must stop.
+func (e *Evaluator) step() *object.Error {
+	e.steps++
+	if e.limits.MaxSteps > 0 && e.steps > e.limits.MaxSteps {
+		return newLimitError("step limit exceeded: %d", e.limits.MaxSteps)
+	}
+	if e.steps%ctxCheckInterval == 0 {
+		select {
+		case <-e.ctx.Done():
+			return newLimitError("%v", e.ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+// allocate accounts for size bytes of strings, arrays or hashes about to be
+// allocated.
+func (e *Evaluator) allocate(size int64) *object.Error {
+	return e.charge(size, nil)
+}
+
+// allocated accounts for obj, which has just been created. It returns obj,
+// or the error if obj goes over the quota.
+func (e *Evaluator) allocated(obj object.Object) object.Object {
+	if err := e.charge(object.SizeOf(obj), obj); err != nil {
+		return err
+	}
+	return obj
+}
+
+// charge adds size bytes to the estimate of the memory in use. The estimate
+// only grows with allocations; when it goes over the quota it is recounted
+// from the values reachable from the active calls, the held values and obj,
+// the new value if it exists already. Only if the recount is still over the
+// quota does charge report an error.
+//
+// A recount walks all the memory in use, so it is not made again until as
+// much as it found has been allocated since. In between, the run can go over
+// the quota by up to that much, which keeps loops that make garbage as fast
+// as they keep values from recounting on every allocation.
+func (e *Evaluator) charge(size int64, obj object.Object) *object.Error {
+	if e.limits.MaxMemory <= 0 || size <= 0 {
+		return nil
+	}
+	e.memory += size
+	if e.memory <= e.limits.MaxMemory || e.memory < 2*e.live {
+		return nil
+	}
+	roots := e.temps
+	if obj != nil {
+		roots = append(roots[:len(roots):len(roots)], obj)
+	}
+	e.memory = object.Footprint(e.envs, roots)
+	if obj == nil {
+		e.memory += size
+	}
+	e.live = e.memory
+	if e.memory > e.limits.MaxMemory {
+		e.memory -= size
+		return newResourceError("memory quota exceeded: %d bytes", e.limits.MaxMemory)
+	}
+	return nil
+}
+
+// hold keeps obj, a value held only by the expression being evaluated,
+// visible to the recount of charge until release is called with the mark
+// hold returns.
+func (e *Evaluator) hold(obj object.Object) int {
+	mark := len(e.temps)
+	if e.limits.MaxMemory > 0 {
+		e.temps = append(e.temps, obj)
+	}
+	return mark
+}
+
+func (e *Evaluator) release(mark int) {
+	if len(e.temps) > mark {
+		e.temps = e.temps[:mark]
+	}
+}
+
+func (e *Evaluator) maxDepth() int {
+	if e.limits.MaxDepth > 0 {
+		return e.limits.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+func (e *Evaluator) recoverPanic(result *object.Object) {
+	if r := recover(); r != nil {
+		e.depth = 0
+		*result = newError("internal error: %v", r)
+	}
+}
+
+// eval evaluates node. An error raised while evaluating node is stamped with
+// the position of the innermost node that produced it.
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
+	var result object.Object
+	if err := e.step(); err != nil {
+		result = err
+	} else {
+		result = e.evalNode(node, env)
+	}
+	return e.stampError(result, node)
+}
+
+// evalTail is eval for a node in tail position of a function body. A call
+// there to a Monkey function is not made but returned as a tailCall, for
+// applyFunction to make once the body is done.
+func (e *Evaluator) evalTail(node ast.Node, env *object.Environment) object.Object {
+	if err := e.step(); err != nil {
+		return e.stampError(err, node)
+	}
+	var result object.Object
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		result = e.evalTail(node.Expression, env)
+	case *ast.BlockStatement:
+		result = e.evalBlockStatement(node, env, true)
+	case *ast.IfExpression:
+		result = e.evalIfExpression(node, env, true)
+	case *ast.CallExpression:
+		result = e.evalCallExpression(node, env, true)
+	default:
+		result = e.evalNode(node, env)
+	}
+	return e.stampError(result, node)
+}
+
+func (e *Evaluator) evalBranch(node ast.Node, env *object.Environment, tail bool) object.Object {
+	if tail {
+		return e.evalTail(node, env)
+	}
+	return e.eval(node, env)
+}
+
+// stampError stamps an error that has just been raised with the position of
+// node and the active calls. An error passed on from elsewhere keeps its own.
+func (e *Evaluator) stampError(result object.Object, node ast.Node) object.Object {
+	err, ok := result.(*object.Error)
+	if !ok {
+		return result
+	}
+	if !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	if err.Stack == nil && len(e.calls) > 0 {
+		err.Stack = make([]object.StackFrame, len(e.calls))
+		for i, frame := range e.calls {
+			err.Stack[len(e.calls)-1-i] = frame
+		}
+	}
+	return err
+}
+
+func (e *Evaluator) evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.eval(node.Expression, env)
 
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := e.eval(node.Right, env)
+		if isSignal(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return e.evalLogicalExpression(node, env)
+		}
+		left := e.eval(node.Left, env)
+		if isSignal(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
-		if isError(right) {
+		mark := e.hold(left)
+		right := e.eval(node.Right, env)
+		e.release(mark)
+		if isSignal(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return e.evalInfixExpression(node.Operator, left, right)
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(node, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env, false)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env, false)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		// the operand of a return is in tail position of the function
+		// being run, if any, unless a try statement has yet to finish
+		val := e.evalBranch(node.ReturnValue, env, e.depth > e.runDepth && e.tryDepth == 0)
+		if isSignal(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := e.eval(node.Value, env)
+		if isSignal(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return e.evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return breakSignal
+	case *ast.ContinueStatement:
+		return continueSignal
+	case *ast.ThrowStatement:
+		val := e.eval(node.Value, env)
+		if isSignal(val) {
+			return val
+		}
+		return &object.Error{Message: ThrownMessage(val), Thrown: val}
+	case *ast.TryStatement:
+		return e.evalTryStatement(node, env)
 
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return e.evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Env: env, Body: body, Name: node.Name}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
-		}
-		return applyFunction(function, args)
+		return e.evalCallExpression(node, env, false)
 	// string
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
+	case *ast.TemplateLiteral:
+		return e.evalTemplateLiteral(node, env)
+
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		elements := e.evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isSignal(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return e.allocated(&object.Array{Elements: elements})
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := e.eval(node.Left, env)
+		if isSignal(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
-		if isError(index) {
+		mark := e.hold(left)
+		index := e.eval(node.Index, env)
+		e.release(mark)
+		if isSignal(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.evalHashLiteral(node, env)
 	}
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		result = e.eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
@@ -117,20 +440,206 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment, tail bool) object.Object {
 	var result object.Object
-	for _, statement := range block.Statements {
-		result = Eval(statement, env)
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+	for i, statement := range block.Statements {
+		result = e.evalBranch(statement, env, tail && i == len(block.Statements)-1)
+		if isSignal(result) {
+			return result
+		}
+	}
+	return result
+}
+
+func (e *Evaluator) evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := e.eval(ws.Condition, env)
+		if isSignal(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+		if result, done := loopResult(e.eval(ws.Body, env)); done {
+			return result
+		}
+	}
+}
+
+func (e *Evaluator) evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	if fs.Init != nil {
+		if init := e.eval(fs.Init, env); isSignal(init) {
+			return init
+		}
+	}
+	for {
+		if fs.Condition != nil {
+			condition := e.eval(fs.Condition, env)
+			if isSignal(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return nil
+			}
+		}
+		if result, done := loopResult(e.eval(fs.Body, env)); done {
+			return result
+		}
+		if fs.Post != nil {
+			// the post statement is part of the loop: a break in it ends
+			// the loop and a continue goes on to the condition
+			if result, done := loopResult(e.eval(fs.Post, env)); done {
 				return result
 			}
 		}
 	}
+}
+
+// loopResult handles the result of one run of a loop body. It reports
+// whether the loop is done, and if so what the loop evaluates to: a return
+// value or error is passed on, a break ends the loop.
+func loopResult(result object.Object) (object.Object, bool) {
+	if result == nil {
+		return nil, false
+	}
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	case object.BREAK_OBJ:
+		return nil, true
+	}
+	return nil, false
+}
+
+// evalTryStatement runs the body of a try statement, then its catch clause if
+// the body raised an error that can be caught, then its finally clause. A
+// return, break, continue or error of the finally clause replaces that of
+// the body or catch clause. An error that cannot be caught skips the
+// finally clause too.
+func (e *Evaluator) evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	e.tryDepth++
+	result := e.eval(ts.Body, env)
+	if err, ok := result.(*object.Error); ok && err.Kind.Catchable() && ts.Catch != nil {
+		caught := e.allocated(CaughtValue(err.Thrown, err.Message, err.Stack))
+		if isError(caught) {
+			result = caught
+		} else {
+			env.Set(ts.Param.Value, caught)
+			result = e.eval(ts.Catch, env)
+		}
+	}
+	e.tryDepth--
+	if err, ok := result.(*object.Error); ok && !err.Kind.Catchable() {
+		return result
+	}
+
+	if ts.Finally != nil {
+		if finally := e.eval(ts.Finally, env); isSignal(finally) {
+			return finally
+		}
+	}
+	// like a loop, a try statement has no value of its own
+	if isSignal(result) {
+		return result
+	}
+	return nil
+}
+
+// isSignal reports whether obj ends the enclosing blocks: a return value, an
+// error, or the signal of a break or continue.
+func isSignal(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
+}
+
+// ThrownMessage is the message of the error raised by throwing value: the
+// string itself, the message of a hash with a string "message" key, or else
+// the value as inspected.
+func ThrownMessage(value object.Object) string {
+	switch value := value.(type) {
+	case *object.String:
+		return value.Value
+	case *object.Hash:
+		key := &object.String{Value: "message"}
+		if pair, ok := value.Pairs[key.HashKey()]; ok {
+			if message, ok := pair.Value.(*object.String); ok {
+				return message.Value
+			}
+		}
+	}
+	return value.Inspect()
+}
+
+// CaughtValue is the value a catch clause binds for an error: a hash of its
+// "message" and "stack", the latter an array with a string per call,
+// innermost first. A hash thrown by a throw statement keeps its own fields,
+// so that rethrowing it keeps them too, and gains "message" and "stack" only
+// where it lacks them.
+func CaughtValue(thrown object.Object, message string, stack []object.StackFrame) object.Object {
+	hash := object.NewHash()
+	if thrown, ok := thrown.(*object.Hash); ok {
+		for _, key := range thrown.Keys {
+			hash.Set(key, thrown.Pairs[key])
+		}
+	}
+	frames := make([]object.Object, len(stack))
+	for i, frame := range stack {
+		frames[i] = &object.String{Value: frame.String()}
+	}
+	for _, pair := range []object.HashPair{
+		{Key: &object.String{Value: "message"}, Value: &object.String{Value: message}},
+		{Key: &object.String{Value: "stack"}, Value: &object.Array{Elements: frames}},
+	} {
+		key := pair.Key.(*object.String).HashKey()
+		if _, ok := hash.Pairs[key]; !ok {
+			hash.Set(key, pair)
+		}
+	}
+	return hash
+}
+
+// evalInfixExpression is evalInfixExpression accounting for the memory of a
+// string concatenation before the string is built.
+func (e *Evaluator) evalInfixExpression(operator string, left, right object.Object) object.Object {
+	if operator == "+" && left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		size := len(left.(*object.String).Value) + len(right.(*object.String).Value)
+		if err := e.allocate(object.StringSize(size)); err != nil {
+			return err
+		}
+	}
+	result := evalInfixExpression(operator, left, right)
+	if result.Type() == object.BIGINT_OBJ {
+		return e.allocated(result)
+	}
 	return result
 }
 
+// EvalPrefixExpression, EvalInfixExpression and EvalIndexExpression apply an
+// operator to already evaluated operands. The vm uses them so that both
+// backends share a single definition of the operator semantics.
+func EvalPrefixExpression(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func EvalInfixExpression(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func EvalIndexExpression(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// IsTruthy reports whether obj counts as true in a condition.
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
 func nativeBoolToBooleanObject(value bool) *object.Boolean {
 	if value {
 		return TRUE
@@ -144,11 +653,24 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalBitNotOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
+func evalBitNotOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInt:
+		return integerObject(new(big.Int).Not(right.Value))
+	default:
+		return newError("unknown operator: ~%s", right.Type())
+	}
+}
+
 func evalBangOperatorExpression(right object.Object) object.Object {
 	switch right {
 	case TRUE:
@@ -163,21 +685,35 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			return integerObject(new(big.Int).Neg(toBig(right)))
+		}
+		return &object.Integer{Value: -right.Value}
+	case *object.BigInt:
+		return integerObject(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isInteger(left) && isInteger(right):
+		return evalBigIntInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalBooleanInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case left.Type() == object.ARRAY_OBJ && right.Type() == object.ARRAY_OBJ:
+		return evalArrayInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -185,22 +721,71 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 	}
 }
 
+// evalIntegerInfixExpression handles two integers. A result that overflows
+// an int64 is computed again as a BigInt.
 func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		sum := leftVal + rightVal
+		if (rightVal > 0 && sum < leftVal) || (rightVal < 0 && sum > leftVal) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: sum}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		diff := leftVal - rightVal
+		if (rightVal > 0 && diff > leftVal) || (rightVal < 0 && diff < leftVal) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: diff}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		product := leftVal * rightVal
+		if leftVal != 0 && (product/leftVal != rightVal || (leftVal == -1 && rightVal == math.MinInt64)) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: product}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			shifted := leftVal << uint64(rightVal)
+			if leftVal != 0 && (rightVal >= 64 || shifted>>uint64(rightVal) != leftVal) {
+				return evalBigIntInfixExpression(operator, left, right)
+			}
+			return &object.Integer{Value: shifted}
+		}
+		// as in Go, >> is arithmetic and a shift by 64 or more leaves no
+		// bits but the sign
+		return &object.Integer{Value: leftVal >> uint64(rightVal)}
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -210,6 +795,134 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	}
 }
 
+// MaxShift is the largest count a value may be shifted left by, so that a
+// single shift cannot build an integer of unbounded size.
+const MaxShift = 1 << 16
+
+// evalBigIntInfixExpression handles two integers, one of them at least a
+// BigInt or with a result that overflows an int64.
+func evalBigIntInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal, rightVal := toBig(left), toBig(right)
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(leftVal, rightVal)
+	case "-":
+		result.Sub(leftVal, rightVal)
+	case "*":
+		result.Mul(leftVal, rightVal)
+	case "/", "%":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		// truncated, as with int64
+		if operator == "/" {
+			result.Quo(leftVal, rightVal)
+		} else {
+			result.Rem(leftVal, rightVal)
+		}
+	case "&":
+		result.And(leftVal, rightVal)
+	case "|":
+		result.Or(leftVal, rightVal)
+	case "^":
+		result.Xor(leftVal, rightVal)
+	case "<<":
+		if rightVal.Sign() < 0 {
+			return newError("negative shift count: %s", rightVal)
+		}
+		if rightVal.Cmp(big.NewInt(MaxShift)) > 0 {
+			return newError("shift count too large: %s", rightVal)
+		}
+		result.Lsh(leftVal, uint(rightVal.Int64()))
+	case ">>":
+		if rightVal.Sign() < 0 {
+			return newError("negative shift count: %s", rightVal)
+		}
+		if rightVal.IsInt64() && rightVal.Int64() <= int64(leftVal.BitLen()) {
+			result.Rsh(leftVal, uint(rightVal.Int64()))
+		} else if leftVal.Sign() < 0 {
+			result.SetInt64(-1)
+		}
+	case ">", "<", ">=", "<=", "==", "!=":
+		return nativeBoolToBooleanObject(compareResult(operator, leftVal.Cmp(rightVal)))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+	return integerObject(result)
+}
+
+// integerObject returns value as an Integer if it fits in one, and as a
+// BigInt otherwise.
+func integerObject(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return &object.Integer{Value: value.Int64()}
+	}
+	return &object.BigInt{Value: value}
+}
+
+func isInteger(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.INTEGER_OBJ || t == object.BIGINT_OBJ
+}
+
+func toBig(obj object.Object) *big.Int {
+	if obj, ok := obj.(*object.BigInt); ok {
+		return obj.Value
+	}
+	return big.NewInt(obj.(*object.Integer).Value)
+}
+
+// evalFloatInfixExpression handles two floats, or an integer and a float,
+// in which case the integer is promoted to a float.
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *object.Float:
+		return obj.Value
+	}
+	return 0
+}
+
 func evalBooleanInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	leftVal := left.(*object.Boolean).Value
 	rightVal := right.(*object.Boolean).Value
@@ -231,72 +944,391 @@ func evalStringInfixExpression(operator string, left object.Object, right object
 	switch operator {
 	case "+":
 		return &object.String{Value: leftVal + rightVal}
+	case "==", "!=", "<", ">", "<=", ">=":
+		return nativeBoolToBooleanObject(compareResult(operator, strings.Compare(leftVal, rightVal)))
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+// evalArrayInfixExpression compares arrays: == and != element by element,
+// and the ordering operators lexicographically.
+func evalArrayInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(objectsEqual(left, right))
+	case "!=":
+		return nativeBoolToBooleanObject(!objectsEqual(left, right))
+	case "<", ">", "<=", ">=":
+		cmp, err := compareObjects(operator, left, right)
+		if err != nil {
+			return err
+		}
+		return nativeBoolToBooleanObject(compareResult(operator, cmp))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// compareResult applies a comparison operator to the result of a three-way
+// comparison.
+func compareResult(operator string, cmp int) bool {
+	switch operator {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// compareObjects orders numbers, strings and arrays of those, returning
+// -1, 0 or 1. Other values cannot be ordered.
+func compareObjects(operator string, left, right object.Object) (int, *object.Error) {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		l, r := left.(*object.Integer).Value, right.(*object.Integer).Value
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	case isInteger(left) && isInteger(right):
+		return toBig(left).Cmp(toBig(right)), nil
+	case isNumber(left) && isNumber(right):
+		l, r := toFloat(left), toFloat(right)
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return strings.Compare(left.(*object.String).Value, right.(*object.String).Value), nil
+	case left.Type() == object.ARRAY_OBJ && right.Type() == object.ARRAY_OBJ:
+		l, r := left.(*object.Array).Elements, right.(*object.Array).Elements
+		for i := 0; i < len(l) && i < len(r); i++ {
+			cmp, err := compareObjects(operator, l[i], r[i])
+			if err != nil || cmp != 0 {
+				return cmp, err
+			}
+		}
+		return len(l) - len(r), nil
+	case left.Type() != right.Type():
+		return 0, newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	default:
+		return 0, newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// objectsEqual reports whether two values are equal, comparing arrays and
+// hashes by their contents and numbers by value.
+func objectsEqual(left, right object.Object) bool {
+	switch {
+	case isNumber(left) && isNumber(right):
+		if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+			return left.(*object.Integer).Value == right.(*object.Integer).Value
+		}
+		if isInteger(left) && isInteger(right) {
+			return toBig(left).Cmp(toBig(right)) == 0
+		}
+		return toFloat(left) == toFloat(right)
+	case left.Type() != right.Type():
+		return false
+	}
+	switch left := left.(type) {
+	case *object.String:
+		return left.Value == right.(*object.String).Value
+	case *object.Array:
+		r := right.(*object.Array).Elements
+		if len(left.Elements) != len(r) {
+			return false
+		}
+		for i, el := range left.Elements {
+			if !objectsEqual(el, r[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		r := right.(*object.Hash).Pairs
+		if len(left.Pairs) != len(r) {
+			return false
+		}
+		for key, pair := range left.Pairs {
+			other, ok := r[key]
+			if !ok || !objectsEqual(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return left == right
+	}
+}
+
+// evalLogicalExpression evaluates && and ||. The right operand is only
+// evaluated when the left one does not decide the result.
+func (e *Evaluator) evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := e.eval(node.Left, env)
+	if isSignal(left) {
+		return left
+	}
+	if isTruthy(left) == (node.Operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+	right := e.eval(node.Right, env)
+	if isSignal(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment, tail bool) object.Object {
+	condition := e.eval(ie.Condition, env)
+	if isSignal(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return e.evalBranch(ie.Consequence, env, tail)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.evalBranch(ie.Alternative, env, tail)
 	}
 	return NULL
 }
 
-func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+func (e *Evaluator) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	val, ok := env.Get(node.Value)
 	if ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := e.builtins[node.Value]; ok {
 		return builtin
 	}
 	return newError("identifier not found: " + node.Value)
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
-	for _, e := range exps {
-		evaluated := Eval(e, env)
-		if isError(evaluated) {
+	defer e.release(len(e.temps))
+	for _, exp := range exps {
+		evaluated := e.eval(exp, env)
+		if isSignal(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
+		e.hold(evaluated)
 	}
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// tailCall is a call in tail position of a function body, made by the
+// applyFunction running that body after it returns.
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+	pos  token.Position
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+func (e *Evaluator) evalCallExpression(node *ast.CallExpression, env *object.Environment, tail bool) object.Object {
+	function := e.eval(node.Function, env)
+	if isSignal(function) {
+		return function
+	}
+	mark := e.hold(function)
+	args := e.evalExpressions(node.Arguments, env)
+	e.release(mark)
+	if len(args) == 1 && isSignal(args[0]) {
+		return args[0]
+	}
+	if fn, ok := function.(*object.Function); ok && tail {
+		if err := checkArity(fn, args); err != nil {
+			return err
+		}
+		return &tailCall{fn: fn, args: args, pos: node.Pos()}
+	}
+	return e.applyFunction(function, args, node.Pos())
+}
+
+// applyFunction calls fn with args from pos. The calls in tail position of a
+// Monkey function are made in a loop here rather than by recursion, so that
+// they take no Go stack and do not count towards the call depth. Each
+// replaces the caller in the stack trace.
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object, pos token.Position) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if err := checkArity(fn, args); err != nil {
+			return err
+		}
+		if e.depth >= e.maxDepth() {
+			return newLimitError("stack overflow")
+		}
+		e.depth++
+		tryDepth := e.tryDepth
+		e.tryDepth = 0
+		defer func() {
+			e.depth--
+			e.tryDepth = tryDepth
+		}()
+
+		e.envs = append(e.envs, nil)
+		e.calls = append(e.calls, object.StackFrame{})
+		defer func() {
+			e.envs = e.envs[:len(e.envs)-1]
+			e.calls = e.calls[:len(e.calls)-1]
+		}()
+		for {
+			extendedEnv := extendFunctionEnv(fn, args)
+			e.envs[len(e.envs)-1] = extendedEnv
+			e.calls[len(e.calls)-1] = object.StackFrame{Function: fn.Name, Pos: pos}
+			evaluated := unwrapReturnValue(e.evalTail(fn.Body, extendedEnv))
+			call, ok := evaluated.(*tailCall)
+			if !ok {
+				return evaluated
+			}
+			fn, args, pos = call.fn, call.args, call.pos
+		}
 	case *object.Builtin:
-		return fn.Fn(args...)
+		return e.allocated(callBuiltin(fn, args))
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// callBuiltin runs a builtin, turning a panic in its Go code into an error.
+func callBuiltin(fn *object.Builtin, args []object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error in builtin function: %v", r)
+		}
+	}()
+	if result := fn.Fn(args...); result != nil {
+		return result
+	}
+	return NULL
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
+// evalAssignExpression evaluates `target = value` and the compound forms
+// such as `target += value`, which read the target before the value is
+// evaluated. Assignment evaluates to the value assigned.
+func (e *Evaluator) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if node.Operator != "=" {
+			current = e.eval(target, env)
+			if isSignal(current) {
+				return current
+			}
+		}
+		val := e.evalAssignedValue(node, current, env)
+		if isSignal(val) {
+			return val
+		}
+		if !env.Assign(target.Value, val) {
+			if _, ok := e.builtins[target.Value]; ok {
+				return newError("cannot assign to builtin: %s", target.Value)
+			}
+			return newError("identifier not found: " + target.Value)
+		}
+		return val
+
+	case *ast.IndexExpression:
+		left := e.eval(target.Left, env)
+		if isSignal(left) {
+			return left
+		}
+		defer e.release(e.hold(left))
+		index := e.eval(target.Index, env)
+		if isSignal(index) {
+			return index
+		}
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isSignal(current) {
+				return current
+			}
+		}
+		val := e.evalAssignedValue(node, current, env)
+		if isSignal(val) {
+			return val
+		}
+		size := object.SizeOf(left)
+		result := evalIndexAssignment(left, index, val)
+		if err := e.allocate(object.SizeOf(left) - size); err != nil {
+			return err
+		}
+		return result
+	}
+	return newError("cannot assign to %s", node.Target.String())
+}
+
+// evalAssignedValue evaluates the value of an assignment and, for a compound
+// assignment, combines it with the current value of the target.
+func (e *Evaluator) evalAssignedValue(node *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	val := e.eval(node.Value, env)
+	if isSignal(val) || current == nil {
+		return val
+	}
+	return e.evalInfixExpression(strings.TrimSuffix(node.Operator, "="), current, val)
+}
+
+// EvalIndexAssignment stores value at index of left, as in `left[index] =
+// value`, and returns value.
+func EvalIndexAssignment(left, index, value object.Object) object.Object {
+	return evalIndexAssignment(left, index, value)
+}
+
+func evalIndexAssignment(left, index, value object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		idx := index.(*object.Integer).Value
+		if idx < 0 || idx >= int64(len(elements)) {
+			return newError("index out of range: %d", idx)
+		}
+		elements[idx] = value
+		return value
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key.HashKey(), object.HashPair{Key: index, Value: value})
+		return value
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
@@ -307,6 +1339,51 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+// evalStringIndexExpression returns the code point at index as a string.
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	idx := index.(*object.Integer).Value
+	if idx < 0 {
+		return NULL
+	}
+	for _, r := range str.(*object.String).Value {
+		if idx == 0 {
+			return &object.String{Value: string(r)}
+		}
+		idx--
+	}
+	return NULL
+}
+
+// evalTemplateLiteral joins the strings of node and the values of its
+// expressions as interpolated, accounting for the memory of the result
+// before it is built.
+func (e *Evaluator) evalTemplateLiteral(node *ast.TemplateLiteral, env *object.Environment) object.Object {
+	parts := []string{node.Strings[0]}
+	size := len(node.Strings[0])
+	for i, exp := range node.Expressions {
+		value := e.eval(exp, env)
+		if isSignal(value) {
+			return value
+		}
+		text := Interpolate(value)
+		parts = append(parts, text, node.Strings[i+1])
+		size += len(text) + len(node.Strings[i+1])
+	}
+	if err := e.allocate(object.StringSize(size)); err != nil {
+		return err
+	}
+	return &object.String{Value: strings.Join(parts, "")}
+}
+
+// Interpolate is the text of value embedded in a template literal: value
+// as inspected, so that a string is embedded without quotes.
+func Interpolate(value object.Object) string {
+	if value == nil {
+		return NULL.Inspect()
+	}
+	return value.Inspect()
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
@@ -320,25 +1397,35 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
-		if isError(key) {
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := object.NewHash()
+	defer e.release(len(e.temps))
+	for _, pair := range node.Pairs {
+		key := e.eval(pair.Key, env)
+		if isSignal(key) {
 			return key
 		}
+		e.hold(key)
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := e.eval(pair.Value, env)
+		if isSignal(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		e.hold(value)
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
 	}
-	return &object.Hash{Pairs: pairs}
+	return e.allocated(hash)
+}
+
+func checkArity(fn *object.Function, args []object.Object) *object.Error {
+	if len(args) != len(fn.Parameters) {
+		return newError("wrong number of arguments: want=%d, got=%d",
+			len(fn.Parameters), len(args))
+	}
+	return nil
 }
 
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
@@ -373,6 +1460,14 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func newResourceError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: object.ResourceError}
+}
+
+func newLimitError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: object.LimitError}
+}
+
 // check parse error
 func isError(obj object.Object) bool {
 	if obj != nil {
@@ -1,19 +1,79 @@
-package evaluator
+package evaluator_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"example.com/m/compiler"
+	"example.com/m/evaluator"
 	"example.com/m/lexer"
 	"example.com/m/object"
 	"example.com/m/parser"
+	"example.com/m/vm"
 )
 
-func testEval(input string) object.Object {
+// testEval evaluates input with the tree-walking evaluator and checks that
+// the compiler and vm produce the same result.
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	env := object.NewEnvironment()
-	return Eval(program, env)
+	evaluated := evaluator.Eval(program, env)
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Errorf("compiler error for %q: %s", input, err)
+		return evaluated
+	}
+	machine := vm.New(comp.Bytecode())
+	var compiled object.Object
+	if err := machine.Run(); err != nil {
+		compiled = vm.ErrorObject(err)
+	} else {
+		compiled = machine.LastPoppedStackElem()
+	}
+	if !backendsAgree(evaluated, compiled) {
+		t.Errorf("backends disagree on %q. evaluator=%s, vm=%s",
+			input, inspect(evaluated), inspect(compiled))
+	}
+	return evaluated
+}
+
+func backendsAgree(evaluated, compiled object.Object) bool {
+	switch evaluated := evaluated.(type) {
+	case nil:
+		return true
+	case *object.Error:
+		err, ok := compiled.(*object.Error)
+		return ok && err.Message == evaluated.Message && err.Pos == evaluated.Pos &&
+			err.StackTrace() == evaluated.StackTrace()
+	case *object.Function:
+		_, ok := compiled.(*object.Closure)
+		return ok
+	case *object.Hash:
+		hash, ok := compiled.(*object.Hash)
+		if !ok || len(hash.Pairs) != len(evaluated.Pairs) {
+			return false
+		}
+		for key, pair := range evaluated.Pairs {
+			other, ok := hash.Pairs[key]
+			if !ok || !backendsAgree(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return compiled != nil && inspect(evaluated) == inspect(compiled)
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
 }
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -23,6 +83,11 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}{
 		{"5", 5},
 		{"10", 10},
+		{"0xff + 0XFF", 510},
+		{"0b1010", 10},
+		{"0o17", 15},
+		{"1_000_000", 1000000},
+		{"0x7FFF_FFFF_FFFF_FFFF", 9223372036854775807},
 		{"-5", -5},
 		{"-10", -10},
 		{"5 + 5 + 5 + 5 - 10", 10},
@@ -36,9 +101,12 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"10 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 + 7 % 4 * 2", 8},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -83,9 +151,29 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{"2.5 >= 2", true},
+		{"1 <= 0.5", false},
+
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" < "b"`, true},
+		{`"abc" > "abd"`, false},
+		{`"ab" <= "abc"`, true},
+		{`"b" >= "abc"`, true},
+
+		{"[1, 2] == [1, 2]", true},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] != [1, 2.0]", false},
+		{`[1, "a"] == [1, "b"]`, false},
+		{"[1, 2] < [1, 3]", true},
+		{"[1, 2] < [1, 2, 0]", true},
+		{`["b"] > ["a", "z"]`, true},
+		{"[] >= []", true},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -103,6 +191,28 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	return true
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 && 0", true},
+		{`"" && false`, false},
+		{"if (false) { 1 } || 0", true},
+		{"false && foobar", false},
+		{"true || 1 / 0", true},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -116,7 +226,7 @@ func TestBangOperator(t *testing.T) {
 		{"!!5", true},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -135,7 +245,7 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 < 2) { 10 } else { 20 }", 10},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -147,7 +257,7 @@ func TestIfElseExpressions(t *testing.T) {
 
 func testNullObject(t *testing.T, obj object.Object) bool {
 	t.Helper()
-	if obj != NULL {
+	if obj != evaluator.NULL {
 		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
 		return false
 	}
@@ -172,11 +282,214 @@ func TestReturnStatements(t *testing.T) {
 		}`, 10},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let i = 0; let sum = 0; while (i < 5) { let sum = sum + i; let i = i + 1; } sum", 10},
+		{"let sum = 0; for (let i = 0; i < 5; let i = i + 1) { let sum = sum + i; } sum", 10},
+		{"let sum = 0; for (let i = 0; i < 6; let i = i + 1) { if (i % 2 == 1) { continue; } let sum = sum + i; } sum", 6},
+		{"let i = 0; while (true) { if (i == 7) { break; } let i = i + 1; } i", 7},
+		{"let i = 0; for (;;) { let i = i + 1; if (i > 2) { break } } i", 3},
+		{"let i = 0; while (i < 100000) { let i = i + 1; } i", 100000},
+		{`let n = 0;
+		for (let i = 0; i < 3; let i = i + 1) {
+			for (let j = 0; j < 10; let j = j + 1) {
+				if (j == 2) { break; }
+				let n = n + 1;
+			}
+		}
+		n`, 6},
+		{`let find = fn(arr, x) {
+			for (let i = 0; i < len(arr); let i = i + 1) {
+				if (arr[i] == x) { return i; }
+			}
+			-1
+		};
+		find([5, 6, 7], 7) * 10 + find([5, 6, 7], 9)`, 19},
+		{"let f = fn() { let s = 0; let k = 0; while (k < 4) { let s = s + k; let k = k + 1; } s }; f()", 6},
+		// break and continue in an expression leave the loop as statements do
+		{"let r = 0; for (let i = 0; i < 3; i += 1) { let x = if (i == 1) { break }; r += 1 } r", 1},
+		{"let r = 0; for (let i = 0; i < 3; i += 1) { r += if (i == 1) { continue } else { 1 } } r", 2},
+		{"let f = fn(x) { x }; let r = 0; for (let i = 0; i < 3; i += 1) { r += f(if (i == 1) { break } else { 1 }) } r", 1},
+		{"let r = 0; while (r < 5) { r += 1; [1, if (r == 2) { break } else { 0 }] } r", 2},
+		{"let f = fn() { let x = if (true) { return 7 }; 0 }; f()", 7},
+		// so do return, break and continue in the head of a loop
+		{`let f = fn() { while (if (true) { return 5 } else { true }) { puts("body") }; 0 }; f()`, 5},
+		{"let f = fn() { for (let i = 0; i < 3; i += if (i == 1) { return i * 10 } else { 1 }) {}; 0 }; f()", 10},
+		{"let f = fn() { for (let i = if (true) { return 4 }; i < 3; i += 1) {}; 0 }; f()", 4},
+		{"let r = 0; for (let i = 0; i < 5; if (i == 2) { break } else { i += 1 }) { r += 1 } r", 3},
+		{"let r = 0; for (let i = 0; i < 5; if (i < 9) { i += 2; continue }) { r += 1 } r", 3},
+		{"let r = 0; for (let i = 0; i < 3; i += 1) { while (if (i == 1) { break } else { false }) {} r += 1 } r", 1},
+		// and leave nothing behind on the stack of the vm
+		{"let i = 0; while (i < 100000) { i += 1; 1 + (if (true) { continue } else { 0 }) }; i", 100000},
+		{"let f = fn(x) { x }; let i = 0; while (true) { i += 1; f([i, if (i == 100000) { break } else { 0 }]) }; i", 100000},
+		{"let r = 0; for (let i = 0; i < 3; i += 1) { try { r += [1, if (i == 1) { continue }][0] } finally { r += 10 } } r", 32},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x += 2; x *= 3; x -= 1; x /= 2; x", 4},
+		{"let a = 1; let b = 2; a = b = 7; a + b", 14},
+		{"let x = 0; (x = 4) * 2", 8},
+		{"let total = 0; let add = fn(n) { total += n }; add(3); add(4); total", 7},
+		{"let newCounter = fn() { let c = 0; fn() { c += 1 } }; let next = newCounter(); next(); next(); next()", 3},
+		{"let f = fn() { let c = 0; let inc = fn() { c += 1 }; inc(); inc(); c }; f()", 2},
+		{"let f = fn() { let c = 10; let g = fn() { let h = fn() { c -= 1 }; h(); h() }; g(); c }; f()", 8},
+		{"let a = [1, 2, 3]; a[1] = 20; a[2] += 10; a[0] + a[1] + a[2]", 34},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] *= 10; h["a"] + h["b"]`, 12},
+		{"let i = 0; let s = 0; while (i < 4) { s += i; i += 1; } s", 6},
+		{"let s = 0; for (let i = 1; i <= 3; i += 1) { s = s * 10 + i; } s", 123},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let r = ""; try { throw "boom"; r = "not reached" } catch (e) { r = e["message"] }; r`, "boom"},
+		{`let r = ""; try { {}[fn() {}] } catch (e) { r = e["message"] }; r`, "unusable as hash key: FUNCTION"},
+		{`let r = ""; try { len(1) } catch (e) { r = e["message"] }; r`, "argument to `len` not supported, got INTEGER"},
+		{`let r = 0; try { throw {"message": "bad", "code": 42} } catch (e) { r = e["code"] }; r`, "42"},
+		// a thrown hash gains the message and stack it lacks
+		{`let r = 0; try { throw {"code": 1} } catch (e) { r = [e["code"], e["message"], e["stack"]] }; r`, "[1, {code: 1}, []]"},
+		{`let r = 0; try { throw {"message": "bad", "stack": 5} } catch (e) { r = e }; r`, "{message: bad, stack: 5}"},
+		{`let f = fn() { throw {"code": 2} }; let r = 0; try { f() } catch (e) { r = len(e["stack"]) }; r`, "1"},
+		{`let r = 0; try { r = 1 } catch (e) { r = 2 }; r`, "1"},
+		{`let log = []; let f = fn() { try { return 1 } finally { log = push(log, "done") } }; [f(), log]`, "[1, [done]]"},
+		{"let f = fn() { try { return 1 } finally { return 2 } }; f()", "2"},
+		{"let f = fn() { try { throw 1 } catch (e) { return 3 } finally { 4 } }; f()", "3"},
+		{`let n = 0;
+		for (let i = 0; i < 5; i += 1) {
+			try { if (i == 3) { break } continue } finally { n += 1 }
+		}
+		n`, "4"},
+		{`let check = fn(x) { if (x > 2) { throw "too big" } x };
+		let sum = fn() { try { check(1) + check(5) } catch (e) { return e["message"] } };
+		sum()`, "too big"},
+		{`let fail = fn() { throw "x" };
+		let f = fn() { try { return fail() } catch (e) { return "caught" } };
+		f()`, "caught"},
+		{`let r = [];
+		try {
+			try { throw "inner" } finally { r = push(r, 1) }
+		} catch (e) { r = push(r, e["message"]) }
+		r`, "[1, inner]"},
+		{`let r = [];
+		try {
+			try { throw "first" } catch (e) { throw "second" } finally { r = push(r, "finally") }
+		} catch (e) { r = push(r, e["message"]) }
+		r`, "[finally, second]"},
+		{`let r = ""; try { try { 1 / 0 } catch (e) { throw e } } catch (e) { r = e["message"] }; r`, "division by zero"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%s, got=%s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+
+	// both backends know where the calls on the stack were made
+	input := `let f = fn() { throw "x" };
+let stack = [];
+try { f(); 0 } catch (e) { stack = e["stack"] };
+stack`
+	evaluated := testEval(t, input)
+	if expected := "[f (3:7)]"; inspect(evaluated) != expected {
+		t.Errorf("wrong stack. expected=%s, got=%s", expected, inspect(evaluated))
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0b1100 & 0b1010", 0b1000},
+		{"0b1100 | 0b1010", 0b1110},
+		{"0b1100 ^ 0b1010", 0b0110},
+		{"~0", -1},
+		{"~0xFF & 0xFFF", 0xF00},
+		{"1 << 10", 1024},
+		{"-1 << 63", -9223372036854775808},
+		{"1 << 62", 4611686018427387904},
+		{"0xF0 >> 4", 0xF},
+		{"-16 >> 2", -4},
+		{"-1 >> 100", -1},
+		{"let flags = 0; flags = flags | 1 << 3; flags & 8 == 8 && flags != 0 == true; flags", 8},
+		{"0xCAFE >> 8 & 0xFF", 0xCA},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 1 - 1", "-9223372036854775809"},
+		{"9223372036854775807 * 3", "27670116110564327421"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"1 << 64", "18446744073709551616"},
+		{"3 << 62", "13835058055282163712"},
+		{"let p = 1; for (let i = 0; i < 100; let i = i + 1) { p *= 2 } p", "1267650600228229401496703205376"},
+		{"(1 << 100) % 7", "2"},
+		{"(1 << 100) >> 98", "4"},
+		{"-(1 << 100) >> 200", "-1"},
+		{"~(1 << 64)", "-18446744073709551617"},
+		{"(1 << 64) & 0xFF", "0"},
+		{"(1 << 64) | 1", "18446744073709551617"},
+		{"(1 << 64) + 0.5", "1.8446744073709552e+19"},
+		{"(1 << 64) > 9223372036854775807", "true"},
+		{"(1 << 64) == 1 << 64", "true"},
+		{"(1 << 64) < 1.0", "false"},
+		{"{1 << 64: 1, 2 << 64: 2}[1 << 64]", "1"},
+		{"let h = {}; h[9223372036854775807 + 1] = 1; h[9223372036854775808.0]", "1"},
+		{"{18446744073709551616.0: 1}[1 << 64]", "1"},
+		{"(1 << 64) / 0", "division by zero"},
+		{"1 << (1 << 64)", "shift count too large: 18446744073709551616"},
+		{"(1 << 64) << -1", "negative shift count: -1"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			evaluated = &object.String{Value: errObj.Message}
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// results that fit an int64 are plain integers again
+	testIntegerObject(t, testEval(t, "(9223372036854775807 + 1) - 1"), 9223372036854775807)
+	testIntegerObject(t, testEval(t, "(1 << 64) >> 60"), 16)
+}
+
 func TestErrorHandling(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -186,6 +499,18 @@ func TestErrorHandling(t *testing.T) {
 			"5 + true;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"~true",
+			"unknown operator: ~BOOLEAN",
+		},
+		{
+			"1.5 & 1",
+			"unknown operator: FLOAT & INTEGER",
+		},
 		{
 			"5 + true; 5;",
 			"type mismatch: INTEGER + BOOLEAN",
@@ -206,6 +531,38 @@ func TestErrorHandling(t *testing.T) {
 			"if (10 > 1) { true + false; }",
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
+		{
+			"y = 1",
+			"identifier not found: y",
+		},
+		{
+			"let f = fn() { z += 1 }; f()",
+			"identifier not found: z",
+		},
+		{
+			"let x = 1; x -= true",
+			"type mismatch: INTEGER - BOOLEAN",
+		},
+		{
+			"let a = [1]; a[3] = 2",
+			"index out of range: 3",
+		},
+		{
+			`"abc"[0] = "x"`,
+			"index assignment not supported: STRING",
+		},
+		{
+			"let h = {}; h[fn(x) { x }] = 1",
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			"while (1 + true) { }",
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"for (let i = 0; i < 3; let i = i + \"1\") { }",
+			"type mismatch: INTEGER + STRING",
+		},
 		{
 			`if (10 > 1) {
 				if (10 > 1) {
@@ -226,9 +583,69 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"1.5 + true",
+			"type mismatch: FLOAT + BOOLEAN",
+		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			`"a" < 1`,
+			"type mismatch: STRING < INTEGER",
+		},
+		{
+			"[1, true] < [1, false]",
+			"unknown operator: BOOLEAN < BOOLEAN",
+		},
+		{
+			"true <= false",
+			"unknown operator: BOOLEAN <= BOOLEAN",
+		},
+		{
+			"true && 1 / 0",
+			"division by zero",
+		},
+		{
+			"false || foobar",
+			"identifier not found: foobar",
+		},
+		{
+			"let zero = 0; 10 % zero",
+			"division by zero",
+		},
+		{
+			"let f = fn(n) { 1 + f(n + 1) }; f(0)",
+			"stack overflow",
+		},
+		{
+			"fn(a, b) { a }(1)",
+			"wrong number of arguments: want=2, got=1",
+		},
+		{
+			"-[1.5]",
+			"unknown operator: -ARRAY",
+		},
+		{
+			`throw "boom"`,
+			"boom",
+		},
+		{
+			`let f = fn() { throw [1, 2] }; try { f() } finally { 1 }`,
+			"[1, 2]",
+		},
+		{
+			"try { 1 / 0 } catch (e) { throw e }",
+			"division by zero",
+		},
+		{
+			"let f = fn() { 1 + f() }; try { f() } catch (e) { 0 }",
+			"stack overflow",
+		},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		errObj, ok := evaluated.(*object.Error)
 		if !ok {
 			t.Errorf("no error object returned. got=%T(%+v)",
@@ -253,13 +670,13 @@ func TestLetStatements(t *testing.T) {
 		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
 	}
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	fn, ok := evaluated.(*object.Function)
 	if !ok {
 		t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
@@ -290,7 +707,7 @@ func TestFunctionApplication(t *testing.T) {
 		{"fn(x) { x; }(5)", 5},
 	}
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -301,12 +718,12 @@ func TestClosures(t *testing.T) {
 };
    let addTwo = newAdder(2);
    addTwo(2);`
-	testIntegerObject(t, testEval(input), 4)
+	testIntegerObject(t, testEval(t, input), 4)
 }
 
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
@@ -318,7 +735,7 @@ func TestStringLiteral(t *testing.T) {
 
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
@@ -328,6 +745,60 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"abc"[0]`, "a"},
+		{`"abc"[2]`, "c"},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`let s = "añb"; s[len(s) - 1]`, "b"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-1]`, nil},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if tt.expected == nil {
+			testNullObject(t, evaluated)
+			continue
+		}
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. want=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+}
+
+func TestTemplateLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Ann"; let items = [1, 2]; "Hello ${name}, you have ${len(items)} items"`, "Hello Ann, you have 2 items"},
+		{`"${1.5} ${true} ${[1, "a"]} ${{"k": 2}} ${if (false) { 1 }}"`, "1.5 true [1, a] {k: 2} null"},
+		{`let f = fn(x) { "<${x}>" }; "${f(f("in"))}"`, "<<in>>"},
+		{`"${"a" + "${1 + 2}"}b"`, "a3b"},
+		{`"\${x}"`, "${x}"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. want=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -336,11 +807,13 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo, 世界")`, 9},
+		{`len("\u{1F600}")`, 1},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
@@ -361,7 +834,7 @@ func TestBuiltinFunctions(t *testing.T) {
 
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Array)
 	if !ok {
 		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
@@ -421,7 +894,7 @@ func TestArrayIndexExpressions(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -441,7 +914,7 @@ func TestHashLiterals(t *testing.T) {
            true: 5,
            false: 6
 }`
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Hash)
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
@@ -451,8 +924,8 @@ func TestHashLiterals(t *testing.T) {
 		(&object.String{Value: "two"}).HashKey():   2,
 		(&object.String{Value: "three"}).HashKey(): 3,
 		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+		evaluator.TRUE.HashKey():                   5,
+		evaluator.FALSE.HashKey():                  6,
 	}
 	if len(result.Pairs) != len(expected) {
 		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
@@ -466,6 +939,26 @@ func TestHashLiterals(t *testing.T) {
 	}
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 4, true: 5}`, "{b: 1, a: 2, 3: 4, true: 5}"},
+		// setting a key again keeps its place
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, "{b: 4, a: 2, c: 3}"},
+		{`{"x": 1, "y": 2, "x": 3}`, "{x: 3, y: 2}"},
+		// keys and values are evaluated in source order
+		{`let log = ""; let f = fn(s) { log += s; s }; {f("d"): f("c"), f("b"): f("a")}; log`, "dcba"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -501,7 +994,7 @@ func TestHashIndexExpressions(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -510,3 +1003,254 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{"5 + true;", "1:1"},
+		{"let a = 1;\nlet b = a - foobar;", "2:13"},
+		{"let f = fn(x) {\n  len(x)\n};\nf(1);", "2:3"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong error position. expected=%q, got=%q", tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedTrace string
+	}{
+		{"len(1)", ""},
+		{`let check = fn(x) { len(x) };
+let apply = fn(f, x) { let r = f(x); r };
+apply(check, 1);`, "\tat check (2:32)\n\tat apply (3:1)\n"},
+		{`let make = fn() { fn(x) { x / 0 } };
+let run = fn() { 1 + make()(1) };
+run()`, "\tat <anonymous> (2:22)\n\tat run (3:1)\n"},
+		// a call in tail position replaces its caller
+		{`let fail = fn() { 1 + true };
+let outer = fn() { fail() };
+let main = fn() { let x = outer(); x };
+main()`, "\tat fail (2:20)\n\tat main (4:1)\n"},
+		// a finally clause passes the error on as it was raised
+		{`let g = fn() { len(1) };
+let f = fn() { try { g() } finally { 0 }; 1 };
+f()`, "\tat g (2:22)\n\tat f (3:1)\n"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.StackTrace() != tt.expectedTrace {
+			t.Errorf("wrong stack trace for %q. expected=%q, got=%q", tt.input, tt.expectedTrace, errObj.StackTrace())
+		}
+	}
+
+	// a function called by the host has no call site
+	program := parser.New(lexer.New("let f = fn() { g(); 1 }; let g = fn() { -true }")).ParseProgram()
+	env := object.NewEnvironment()
+	e := evaluator.New()
+	e.Eval(program, env)
+	f, _ := env.Get("f")
+	errObj, ok := e.Apply(f, nil).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+	if expected := "\tat g (1:16)\n\tat f\n"; errObj.StackTrace() != expected {
+		t.Errorf("wrong stack trace. expected=%q, got=%q", expected, errObj.StackTrace())
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999},
+		{"-(1 - 1.25)", 0.25},
+		{"5.5 % 2", 1.5},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	t.Helper()
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func TestFloatComparisonsAndKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 == 1.0", true},
+		{"1.5 > 1", true},
+		{"2 < 1.5", false},
+		{"0.1 + 0.2 != 0.3", true},
+		{`{1: "int"}[1.0]`, "int"},
+		{`{2.0: "float"}[2]`, "float"},
+		{`{0.5: "half"}[0.5]`, "half"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%T (%+v)", tt.input, expected, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestBuiltinPanicIsError(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("boom", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		panic("kaboom")
+	}})
+	program := parser.New(lexer.New("let x = 1;\nboom(x)")).ParseProgram()
+	evaluated := evaluator.Eval(program, env)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	expected := "ERROR: 2:1: internal error in builtin function: kaboom"
+	if errObj.Inspect() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, errObj.Inspect())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	tests := []struct {
+		ctx      context.Context
+		limits   evaluator.Limits
+		input    string
+		expected string
+	}{
+		{context.Background(), evaluator.Limits{MaxSteps: 1000}, "while (true) { }", "step limit exceeded: 1000"},
+		{context.Background(), evaluator.Limits{MaxDepth: 50}, "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", "stack overflow"},
+		{context.Background(), evaluator.Limits{}, "let f = fn() { 1 + f() }; f()", "stack overflow"},
+		{context.Background(), evaluator.Limits{MaxSteps: 100000}, "let f = fn() { f() }; f()", "step limit exceeded: 100000"},
+		{context.Background(), evaluator.Limits{MaxSteps: 1000}, "try { while (true) { } } catch (e) { 0 } finally { 1 }", "step limit exceeded: 1000"},
+		{expired, evaluator.Limits{}, "while (true) { }", "context deadline exceeded"},
+	}
+	e := evaluator.New()
+	for _, tt := range tests {
+		e.SetLimits(tt.limits)
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.EvalContext(tt.ctx, program, object.NewEnvironment())
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected || errObj.Kind != object.LimitError {
+			t.Errorf("wrong error for %q. expected=%q (%s), got=%q (%s)",
+				tt.input, tt.expected, object.LimitError, errObj.Message, errObj.Kind)
+		}
+
+		// the evaluator is still usable after a run was stopped
+		program = parser.New(lexer.New("let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10)")).ParseProgram()
+		testIntegerObject(t, e.Eval(program, object.NewEnvironment()), 0)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(100000, 0)", "100000"},
+		{`let even = fn(n) { if (n == 0) { return true; } return odd(n - 1); };
+		let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		even(100001)`, "false"},
+		{`let f = fn(n) { while (true) { if (n == 0) { return "done"; } return f(n - 1); } }; f(50000)`, "done"},
+		{`let build = fn(n, arr) { if (n == 0) { arr } else { build(n - 1, push(arr, n)) } };
+		let sum = fn(arr, acc) { if (len(arr) == 0) { return acc; } sum(rest(arr), acc + first(arr)) };
+		sum(build(12000, []), 0)`, "72006000"},
+		{"let f = fn(n) { if (n == 0) { len } else { f(n - 1) } }; f(20000)([1, 2])", "2"},
+		// a closure keeps the locals of the frame a tail call replaced
+		{"let f = fn(n, k) { if (n == 0) { k() } else { let m = n; f(n - 1, fn() { m }) } }; f(3, fn() { 0 })", "1"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%s, got=%s", tt.input, tt.expected, inspect(evaluated))
+		}
+	}
+}
+
+func TestMemoryQuota(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let s = "x"; while (true) { s = s + s }`, "memory quota exceeded: 65536 bytes"},
+		{"let a = []; while (true) { a = push(a, 1) }", "memory quota exceeded: 65536 bytes"},
+		{`let a = []; while (true) { a = push(a, "xxxxxxxxxx") }`, "memory quota exceeded: 65536 bytes"},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i += 1 }", "memory quota exceeded: 65536 bytes"},
+		{`try { let s = "x"; while (true) { s += s } } catch (e) { 0 }`, "memory quota exceeded: 65536 bytes"},
+		{`let s = "x"; while (true) { s = "${s}${s}" }`, "memory quota exceeded: 65536 bytes"},
+		{"let n = 3; while (true) { n = n * n }", "memory quota exceeded: 65536 bytes"},
+		{"let keep = fn(n) { if (n == 0) { [] } else { [keep(n - 1), [1, 2, 3, 4, 5, 6, 7, 8]] } }; keep(1000)", "memory quota exceeded: 65536 bytes"},
+		// garbage does not count against the quota
+		{"let i = 0; while (i < 1000) { let a = [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]; i += 1 }; i", 1000},
+		{`let s = ""; for (let i = 0; i < 1000; i += 1) { s = s + "abcdefghij"; if (len(s) > 100) { s = "" } }; len(s)`, 100},
+	}
+	e := evaluator.New()
+	e.SetLimits(evaluator.Limits{MaxMemory: 65536})
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.Eval(program, object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected || errObj.Kind != object.ResourceError {
+				t.Errorf("wrong error for %q. expected=%q (%s), got=%q (%s)",
+					tt.input, expected, object.ResourceError, errObj.Message, errObj.Kind)
+			}
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package lexer
 
 import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
 	"example.com/m/token"
 )
 
@@ -13,19 +17,57 @@ type Lexer struct {
 	position     int
 	readPosition int //current reading position in input (after current char); next char
 	ch           byte
+
+	filename string
+	line     int // line of the current char
+	column   int // column of the current char
+
+	// templates holds, for each ${ of a template literal not yet closed,
+	// the braces opened since, so that its closing brace can be told apart.
+	templates []int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a lexer whose token positions carry the given file name.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tk token.Token
+	var comments []string
+	for {
+		// skip
+		l.skipWhiteSpace()
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			break
+		}
+		pos := l.pos()
+		comment, ok := l.readComment()
+		if !ok {
+			tk := newToken(token.ILLEGAL, "unterminated comment")
+			tk.Pos = pos
+			tk.End = l.pos()
+			tk.Comments = comments
+			return tk
+		}
+		comments = append(comments, comment)
+	}
 
-	// skip
-	l.skipWhiteSpace()
+	pos := l.pos()
+	tk := l.readToken()
+	tk.Pos = pos
+	tk.End = l.pos()
+	tk.Comments = comments
+	return tk
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tk token.Token
 
 	switch l.ch {
 	case '=':
@@ -37,9 +79,9 @@ func (l *Lexer) NextToken() token.Token {
 			tk = newToken(token.ASSIGN, string(l.ch))
 		}
 	case '+':
-		tk = newToken(token.PLUS, string(l.ch))
+		tk = l.readOperator(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tk = newToken(token.MINUS, string(l.ch))
+		tk = l.readOperator(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		c := l.peekChar()
 		if c == '=' {
@@ -48,14 +90,50 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tk = newToken(token.BANG, string(l.ch))
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tk = newToken(token.AND, "&&")
+		} else {
+			tk = newToken(token.BITAND, string(l.ch))
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tk = newToken(token.OR, "||")
+		} else {
+			tk = newToken(token.BITOR, string(l.ch))
+		}
+	case '^':
+		tk = newToken(token.BITXOR, string(l.ch))
+	case '~':
+		tk = newToken(token.BITNOT, string(l.ch))
 	case '*':
-		tk = newToken(token.ASTERISK, string(l.ch))
+		tk = l.readOperator(token.ASTERISK, token.ASTERISK_ASSIGN)
 	case '/':
-		tk = newToken(token.SLASH, string(l.ch))
+		tk = l.readOperator(token.SLASH, token.SLASH_ASSIGN)
+	case '%':
+		tk = newToken(token.PERCENT, string(l.ch))
 	case '<':
-		tk = newToken(token.LT, string(l.ch))
+		if l.peekChar() == '=' {
+			l.readChar()
+			tk = newToken(token.LTEQ, "<=")
+		} else if l.peekChar() == '<' {
+			l.readChar()
+			tk = newToken(token.SHL, "<<")
+		} else {
+			tk = newToken(token.LT, string(l.ch))
+		}
 	case '>':
-		tk = newToken(token.GT, string(l.ch))
+		if l.peekChar() == '=' {
+			l.readChar()
+			tk = newToken(token.GTEQ, ">=")
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tk = newToken(token.SHR, ">>")
+		} else {
+			tk = newToken(token.GT, string(l.ch))
+		}
 	case ',':
 		tk = newToken(token.COMMA, string(l.ch))
 	case ';':
@@ -65,12 +143,30 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tk = newToken(token.RPAREN, string(l.ch))
 	case '{':
+		if n := len(l.templates); n > 0 {
+			l.templates[n-1]++
+		}
 		tk = newToken(token.LBRACE, string(l.ch))
 	case '}':
+		n := len(l.templates)
+		if n > 0 && l.templates[n-1] == 0 {
+			// the end of an embedded expression: the template goes on
+			l.templates = l.templates[:n-1]
+			tk = l.readTemplateString(token.TEMPLATE_MIDDLE, token.TEMPLATE_TAIL)
+			break
+		}
+		if n > 0 {
+			l.templates[n-1]--
+		}
 		tk = newToken(token.RBRACE, string(l.ch))
 	case '"':
-		tk.Type = token.STRING
-		tk.Literal = l.readString()
+		tk = l.readTemplateString(token.TEMPLATE_HEAD, token.STRING)
+	case '`':
+		if str, ok := l.readRawString(); ok {
+			tk = newToken(token.STRING, str)
+		} else {
+			tk = newToken(token.ILLEGAL, "unterminated string")
+		}
 	case '[':
 		tk = newToken(token.LBRACKET, string(l.ch))
 	case ']':
@@ -87,18 +183,33 @@ func (l *Lexer) NextToken() token.Token {
 			tk = newToken(tkType, ident)
 			return tk
 		} else if isDigit(l.ch) {
-			num := l.readNumber()
-			tk = newToken(token.INT, num)
+			tkType, num := l.readNumber()
+			tk = newToken(tkType, num)
 			return tk
 		}
-		tk = newToken(token.ILLEGAL, "")
-		return tk
+		// consume the whole UTF-8 sequence, so the lexer moves on
+		r, size := utf8.DecodeRuneInString(l.input[l.position:])
+		for i := 1; i < size; i++ {
+			l.readChar()
+		}
+		tk = newToken(token.ILLEGAL, string(r))
 	}
 
 	l.readChar()
 	return tk
 }
 
+// readOperator reads an operator that has a compound assignment form, such
+// as + and +=.
+func (l *Lexer) readOperator(op, assignOp token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return newToken(assignOp, string(ch)+"=")
+	}
+	return newToken(op, string(l.ch))
+}
+
 func isLetter(c byte) bool {
 	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_'
 }
@@ -107,7 +218,26 @@ func isDigit(c byte) bool {
 	return '0' <= c && c <= '9'
 }
 
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	default:
+		return int(c - 'A' + 10)
+	}
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0 // the ASCII code for the "NUL" character to indicate there is no character to consume
 	} else {
@@ -115,13 +245,29 @@ func (l *Lexer) readChar() {
 	}
 	l.position = l.readPosition
 	l.readPosition += 1
+	l.column++
+}
+
+// pos returns the position of the current char.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
 }
 
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	return l.peekCharAt(0)
+}
+
+// peekCharAt returns the char offset places after the next char.
+func (l *Lexer) peekCharAt(offset int) byte {
+	if l.readPosition+offset >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	return l.input[l.readPosition+offset]
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -133,23 +279,193 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, or a float when a fraction or an exponent
+// follows the digits: 3.14, 1e-9, 2.5E+3. An integer may have a 0x, 0o or
+// 0b prefix, and digits may be separated by underscores, as in 1_000. The
+// digits are checked by the parser: a prefixed integer takes in all the
+// letters and digits that follow, so that 0xFG is one bad literal rather
+// than 0xF and G.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	position := l.position
-	for isDigit(l.ch) {
+	var tkType token.TokenType = token.INT
+	if l.ch == '0' && strings.IndexByte("xXoObB", l.peekChar()) >= 0 {
+		l.readChar()
+		l.readChar()
+		for isLetter(l.ch) || isDigit(l.ch) {
+			l.readChar()
+		}
+		return tkType, l.input[position:l.position]
+	}
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tkType = token.FLOAT
 		l.readChar()
+		l.readDigits()
 	}
-	return l.input[position:l.position]
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peekCharAt(1))) {
+			tkType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+	return tkType, l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// readDigits reads decimal digits and the underscores between them.
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
+		l.readChar()
+	}
+}
+
+// escapes maps the characters following a backslash in a string to the
+// characters they stand for, but for \u{...}.
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'$':  '$',
+}
+
+// readTemplateString reads a string, or the rest of a template literal
+// from the end of an embedded expression. The token is of type open if the
+// string goes on with a ${, and closed if it ends there.
+func (l *Lexer) readTemplateString(open, closed token.TokenType) token.Token {
+	str, embeds, err := l.readString()
+	if embeds {
+		l.templates = append(l.templates, 0)
+	}
+	switch {
+	case err != "":
+		return newToken(token.ILLEGAL, err)
+	case embeds:
+		return newToken(open, str)
+	default:
+		return newToken(closed, str)
+	}
+}
+
+// readString reads a string up to its closing quote, or up to a ${ starting
+// an embedded expression, and decodes its escape sequences. It reports
+// whether it stopped at a ${. On error, it returns a message: the string is
+// not closed before the end of the input, or has an invalid escape
+// sequence. The rest of the string is read either way.
+func (l *Lexer) readString() (str string, embeds bool, err string) {
+	var out strings.Builder
 	for {
 		l.readChar()
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
+		if l.ch == '$' && l.peekChar() == '{' {
+			l.readChar()
+			return out.String(), true, err
+		}
+		if l.ch != '\\' {
+			out.WriteByte(l.ch)
+			continue
+		}
+		l.readChar()
+		if ch, ok := escapes[l.ch]; ok {
+			out.WriteByte(ch)
+			continue
+		}
+		switch {
+		case l.ch == 0:
+			return "", false, "unterminated string"
+		case l.ch == 'u':
+			r, ok := l.readUnicodeEscape()
+			if ok {
+				out.WriteRune(r)
+			} else if err == "" {
+				err = "invalid unicode escape sequence"
+			}
+		case err == "":
+			err = fmt.Sprintf("unknown escape sequence: \\%c", l.ch)
+		}
 	}
-	return l.input[position:l.position]
+	if l.ch == 0 {
+		return "", false, "unterminated string"
+	}
+	return out.String(), false, err
+}
+
+// readUnicodeEscape reads the {...} of a \u{...} escape sequence: one to six
+// hex digits giving a code point. It reports false if they do not, leaving
+// the current char at the closing brace, or at the first char that cannot
+// be part of the sequence.
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	if l.peekChar() != '{' {
+		return 0, false
+	}
+	l.readChar()
+	var r rune
+	digits := 0
+	for isHexDigit(l.peekChar()) {
+		l.readChar()
+		r = r<<4 | rune(hexValue(l.ch))
+		digits++
+	}
+	if l.peekChar() != '}' {
+		return 0, false
+	}
+	l.readChar()
+	return r, digits > 0 && digits <= 6 && utf8.ValidRune(r)
+}
+
+// readRawString reads a `raw` string, which has no escape sequences and may
+// span lines, up to its closing backtick. It reports false when the string
+// is not closed before the end of the input.
+func (l *Lexer) readRawString() (string, bool) {
+	position := l.position + 1
+	for {
+		l.readChar()
+		if l.ch == '`' || l.ch == 0 {
+			break
+		}
+	}
+	return l.input[position:l.position], l.ch == '`'
+}
+
+// readComment reads a `// line` comment up to the end of the line, or a
+// `/* block */` comment, which may nest. It reports false when a block
+// comment is not closed before the end of the input.
+func (l *Lexer) readComment() (string, bool) {
+	position := l.position
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[position:l.position], true
+	}
+
+	l.readChar()
+	l.readChar()
+	depth := 1
+	for depth > 0 {
+		switch {
+		case l.ch == 0:
+			return l.input[position:l.position], false
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+	}
+	return l.input[position:l.position], true
 }
 
 func (l *Lexer) skipWhiteSpace() {
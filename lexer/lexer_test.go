@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"fmt"
 	"testing"
 
 	"example.com/m/token"
@@ -43,11 +44,17 @@ func TestIllegalChar(t *testing.T) {
 	if x.Type != token.ILLEGAL {
 		t.Fatalf("expected token ILLEGAL, got %q", x.Type)
 	}
+	if x.Literal != "你" {
+		t.Fatalf("expected literal %q, got %q", "你", x.Literal)
+	}
+	if x = l.NextToken(); x.Literal != "好" {
+		t.Fatalf("expected literal %q, got %q", "好", x.Literal)
+	}
 
 }
 
 func TestNextToken(t *testing.T) {
-	input := "(){}+=,;"
+	input := "(){}+ =,;%&&||<=>= += -= *= /= & | ^ ~ << >> <<="
 	tests := []struct {
 		expectedToken   token.TokenType
 		expectedLiteral string
@@ -60,6 +67,23 @@ func TestNextToken(t *testing.T) {
 		{token.ASSIGN, "="},
 		{token.COMMA, ","},
 		{token.SEMICOLON, ";"},
+		{token.PERCENT, "%"},
+		{token.AND, "&&"},
+		{token.OR, "||"},
+		{token.LTEQ, "<="},
+		{token.GTEQ, ">="},
+		{token.PLUS_ASSIGN, "+="},
+		{token.MINUS_ASSIGN, "-="},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.SLASH_ASSIGN, "/="},
+		{token.BITAND, "&"},
+		{token.BITOR, "|"},
+		{token.BITXOR, "^"},
+		{token.BITNOT, "~"},
+		{token.SHL, "<<"},
+		{token.SHR, ">>"},
+		{token.SHL, "<<"},
+		{token.ASSIGN, "="},
 	}
 
 	lexer := New(input)
@@ -84,7 +108,7 @@ let add = fn(x, y) {
 
 let result = add(five, ten);
 
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -227,3 +251,186 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  "ab" + x`
+	tests := []struct {
+		expectedToken token.TokenType
+		expectedPos   string
+		expectedEnd   string
+	}{
+		{token.LET, "main.mk:1:1", "main.mk:1:4"},
+		{token.IDENT, "main.mk:1:5", "main.mk:1:6"},
+		{token.ASSIGN, "main.mk:1:7", "main.mk:1:8"},
+		{token.INT, "main.mk:1:9", "main.mk:1:10"},
+		{token.SEMICOLON, "main.mk:1:10", "main.mk:1:11"},
+		{token.STRING, "main.mk:2:3", "main.mk:2:7"},
+		{token.PLUS, "main.mk:2:8", "main.mk:2:9"},
+		{token.IDENT, "main.mk:2:10", "main.mk:2:11"},
+		{token.EOF, "main.mk:2:11", "main.mk:2:12"},
+	}
+
+	lexer := NewFile("main.mk", input)
+	for i, expected := range tests {
+		tk := lexer.NextToken()
+		if expected.expectedToken != tk.Type {
+			t.Fatalf("tests[%d], token type wrong, expected %q, got %q", i, expected.expectedToken, tk.Type)
+		}
+		if expected.expectedPos != tk.Pos.String() {
+			t.Fatalf("tests[%d], token pos wrong, expected %q, got %q", i, expected.expectedPos, tk.Pos)
+		}
+		if expected.expectedEnd != tk.End.String() {
+			t.Fatalf("tests[%d], token end wrong, expected %q, got %q", i, expected.expectedEnd, tk.End)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 1e-9 2.5E+3 7e 10e3 0xFF 0B1010 0o17 1_000_000 1_000.5 0xFG`
+	tests := []struct {
+		expectedToken   token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "7"},
+		{token.IDENT, "e"},
+		{token.FLOAT, "10e3"},
+		{token.INT, "0xFF"},
+		{token.INT, "0B1010"},
+		{token.INT, "0o17"},
+		{token.INT, "1_000_000"},
+		{token.FLOAT, "1_000.5"},
+		{token.INT, "0xFG"},
+		{token.EOF, ""},
+	}
+	lexer := New(input)
+	for i, expected := range tests {
+		tk := lexer.NextToken()
+		if expected.expectedToken != tk.Type {
+			t.Fatalf("tests[%d], token type wrong, expected %q, got %q", i, expected.expectedToken, tk.Type)
+		}
+		if expected.expectedLiteral != tk.Literal {
+			t.Fatalf("tests[%d], token literal wrong, expected %q, got %q", i, expected.expectedLiteral, tk.Literal)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := New(`"closed" "open`)
+	if tk := l.NextToken(); tk.Type != token.STRING || tk.Literal != "closed" {
+		t.Fatalf("expected STRING %q, got %s %q", "closed", tk.Type, tk.Literal)
+	}
+	if tk := l.NextToken(); tk.Type != token.ILLEGAL || tk.Literal != "unterminated string" {
+		t.Fatalf("expected ILLEGAL %q, got %s %q", "unterminated string", tk.Type, tk.Literal)
+	}
+	if tk := l.NextToken(); tk.Type != token.EOF {
+		t.Fatalf("expected EOF, got %s", tk.Type)
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"a\tb\nc"`, token.STRING, "a\tb\nc"},
+		{`"say \"hi\" \\ \r\0"`, token.STRING, "say \"hi\" \\ \r\x00"},
+		{`"\u{e9}t\u{E9} \u{1F600}"`, token.STRING, "été \U0001F600"},
+		{"`raw \\n \"quoted\"\nsecond line`", token.STRING, "raw \\n \"quoted\"\nsecond line"},
+		{`"\q"`, token.ILLEGAL, "unknown escape sequence: \\q"},
+		{`"\u{}"`, token.ILLEGAL, "invalid unicode escape sequence"},
+		{`"\u{110000}"`, token.ILLEGAL, "invalid unicode escape sequence"},
+		{`"\u41"`, token.ILLEGAL, "invalid unicode escape sequence"},
+		{`"open\"`, token.ILLEGAL, "unterminated string"},
+		{"`open", token.ILLEGAL, "unterminated string"},
+	}
+	for _, tt := range tests {
+		l := New(tt.input + " x")
+		tk := l.NextToken()
+		if tk.Type != tt.expectedType || tk.Literal != tt.expectedLiteral {
+			t.Errorf("wrong token for %s. want=%s %q, got=%s %q", tt.input, tt.expectedType, tt.expectedLiteral, tk.Type, tk.Literal)
+		}
+		// an invalid string is read to its end all the same
+		if tt.expectedType == token.STRING || tt.expectedLiteral != "unterminated string" {
+			if tk := l.NextToken(); tk.Type != token.IDENT {
+				t.Errorf("wrong token after %s. want=IDENT, got=%s %q", tt.input, tk.Type, tk.Literal)
+			}
+		}
+	}
+}
+
+func TestTemplateLiterals(t *testing.T) {
+	input := `"Hi ${name}, ${ {"n": 1}["n"] } and ${"in ${x}"}!" "\${not}" "$5"`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.TEMPLATE_HEAD, "Hi "},
+		{token.IDENT, "name"},
+		{token.TEMPLATE_MIDDLE, ", "},
+		{token.LBRACE, "{"},
+		{token.STRING, "n"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "n"},
+		{token.RBRACKET, "]"},
+		{token.TEMPLATE_MIDDLE, " and "},
+		{token.TEMPLATE_HEAD, "in "},
+		{token.IDENT, "x"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.TEMPLATE_TAIL, "!"},
+		{token.STRING, "${not}"},
+		{token.STRING, "$5"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tk := l.NextToken()
+		if tk.Type != tt.expectedType || tk.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. want=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tk.Type, tk.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 10 / 2; // trailing
+/* block /* nested */ still comment */ x
+/* unterminated`
+	tests := []struct {
+		expectedToken    token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.LET, "let", []string{"// leading"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "10", nil},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// trailing", "/* block /* nested */ still comment */"}},
+		{token.ILLEGAL, "unterminated comment", nil},
+		{token.EOF, "", nil},
+	}
+	lexer := New(input)
+	for i, expected := range tests {
+		tk := lexer.NextToken()
+		if expected.expectedToken != tk.Type {
+			t.Fatalf("tests[%d], token type wrong, expected %q, got %q", i, expected.expectedToken, tk.Type)
+		}
+		if expected.expectedLiteral != tk.Literal {
+			t.Fatalf("tests[%d], token literal wrong, expected %q, got %q", i, expected.expectedLiteral, tk.Literal)
+		}
+		if fmt.Sprint(expected.expectedComments) != fmt.Sprint(tk.Comments) {
+			t.Fatalf("tests[%d], token comments wrong, expected %q, got %q", i, expected.expectedComments, tk.Comments)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package monkey embeds the Monkey interpreter in Go programs.
+//
+//	in := monkey.New()
+//	in.RegisterBuiltin("double", func(args ...object.Object) object.Object {
+//		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}
+//	})
+//	result, err := in.Eval(`double(21)`)
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"example.com/m/evaluator"
+	"example.com/m/lexer"
+	"example.com/m/object"
+	"example.com/m/parser"
+	"example.com/m/token"
+)
+
+// Interpreter runs Monkey source. The globals bound by one call of Eval are
+// visible to the next, and the builtins registered on an Interpreter are
+// visible to its scripts only. An Interpreter must not be used from several
+// goroutines at once.
+type Interpreter struct {
+	env       *object.Environment
+	evaluator *evaluator.Evaluator
+}
+
+func New() *Interpreter {
+	return &Interpreter{
+		env:       object.NewEnvironment(),
+		evaluator: evaluator.New(),
+	}
+}
+
+// ParseError reports the syntax errors of a source passed to Eval.
+type ParseError struct {
+	Errors []*parser.Error
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// RuntimeError is an error raised by a script while it runs. Its Kind is
+// object.LimitError if the script was stopped for exceeding its limits or
+// by its context.
+type RuntimeError struct {
+	Message string
+	Pos     token.Position // where in the source the error was raised
+	Kind    object.ErrorKind
+	Stack   []object.StackFrame // the calls active when it was raised, innermost first
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+// RegisterBuiltin makes fn callable by name from scripts, as if it were one
+// of the standard builtins. fn returns nil to produce null, and an
+// *object.Error to fail the script.
+func (in *Interpreter) RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	in.evaluator.RegisterBuiltin(name, &object.Builtin{Fn: fn})
+}
+
+// SetLimits bounds the steps, call depth and memory of each following call
+// of Eval or Call. A script that exceeds them fails, leaving the Interpreter
+// ready to run the next one.
+func (in *Interpreter) SetLimits(limits evaluator.Limits) {
+	in.evaluator.SetLimits(limits)
+}
+
+// SetGlobal binds name to value, as `let` does at the top level of a script.
+func (in *Interpreter) SetGlobal(name string, value object.Object) {
+	in.env.Set(name, value)
+}
+
+// Global returns the value bound to name at the top level.
+func (in *Interpreter) Global(name string) (object.Object, bool) {
+	return in.env.Get(name)
+}
+
+// Eval runs source and returns the value of its last statement, or null if
+// that statement has no value. A syntax error is returned as a *ParseError
+// and an error raised by the script as a *RuntimeError.
+func (in *Interpreter) Eval(source string) (object.Object, error) {
+	return in.EvalContext(context.Background(), source)
+}
+
+// EvalContext is like Eval, but stops the script when ctx is done.
+func (in *Interpreter) EvalContext(ctx context.Context, source string) (object.Object, error) {
+	return in.EvalFileContext(ctx, "", source)
+}
+
+// EvalFile is like Eval for source read from the file filename, which the
+// positions of its errors name.
+func (in *Interpreter) EvalFile(filename, source string) (object.Object, error) {
+	return in.EvalFileContext(context.Background(), filename, source)
+}
+
+// EvalFileContext is like EvalFile, but stops the script when ctx is done.
+func (in *Interpreter) EvalFileContext(ctx context.Context, filename, source string) (object.Object, error) {
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return nil, &ParseError{Errors: errors}
+	}
+	return result(in.evaluator.EvalContext(ctx, program, in.env))
+}
+
+// Call calls the function bound to the global fnName with args.
+func (in *Interpreter) Call(fnName string, args ...object.Object) (object.Object, error) {
+	return in.CallContext(context.Background(), fnName, args...)
+}
+
+// CallContext is like Call, but stops the function when ctx is done.
+func (in *Interpreter) CallContext(ctx context.Context, fnName string, args ...object.Object) (object.Object, error) {
+	fn, ok := in.env.Get(fnName)
+	if !ok {
+		return nil, &RuntimeError{Message: fmt.Sprintf("identifier not found: %s", fnName)}
+	}
+	return result(in.evaluator.ApplyContext(ctx, fn, args))
+}
+
+func result(obj object.Object) (object.Object, error) {
+	switch obj := obj.(type) {
+	case nil:
+		return evaluator.NULL, nil
+	case *object.Error:
+		return nil, &RuntimeError{Message: obj.Message, Pos: obj.Pos, Kind: obj.Kind, Stack: obj.Stack}
+	}
+	return obj, nil
+}
@@ -0,0 +1,197 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/m/evaluator"
+	"example.com/m/object"
+)
+
+func TestEvalKeepsGlobals(t *testing.T) {
+	in := New()
+	if _, err := in.Eval("let add = fn(a, b) { a + b };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	result, err := in.Eval("add(1, 2)")
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if result.Inspect() != "3" {
+		t.Errorf("wrong result. want=3, got=%s", result.Inspect())
+	}
+}
+
+func TestRegisterBuiltinIsPerInstance(t *testing.T) {
+	in := New()
+	var calls int
+	in.RegisterBuiltin("double", func(args ...object.Object) object.Object {
+		calls++
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}
+	})
+	result, err := in.Eval("double(21)")
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if result.Inspect() != "42" || calls != 1 {
+		t.Errorf("wrong result. want=42 after 1 call, got=%s after %d", result.Inspect(), calls)
+	}
+
+	_, err = New().Eval("double(21)")
+	expected := "1:1: identifier not found: double"
+	if err == nil || err.Error() != expected {
+		t.Errorf("builtin leaked into another interpreter. want=%q, got=%v", expected, err)
+	}
+}
+
+func TestSetGlobalAndCall(t *testing.T) {
+	in := New()
+	in.SetGlobal("greeting", &object.String{Value: "Hello"})
+	if _, err := in.Eval(`let greet = fn(name) { greeting + ", " + name }`); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	result, err := in.Call("greet", &object.String{Value: "Monkey"})
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	if result.Inspect() != "Hello, Monkey" {
+		t.Errorf("wrong result. want=%q, got=%q", "Hello, Monkey", result.Inspect())
+	}
+
+	if _, err := in.Call("greet"); err == nil || err.Error() != "wrong number of arguments: want=1, got=0" {
+		t.Errorf("wrong error for bad arity. got=%v", err)
+	}
+	if _, err := in.Call("missing"); err == nil || err.Error() != "identifier not found: missing" {
+		t.Errorf("wrong error for unknown function. got=%v", err)
+	}
+}
+
+func TestReentrantCall(t *testing.T) {
+	in := New()
+	in.RegisterBuiltin("callback", func(args ...object.Object) object.Object {
+		result, err := in.Call("inc", args...)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return result
+	})
+	in.RegisterBuiltin("nested", func(args ...object.Object) object.Object {
+		result, err := in.Eval("return inc(4)")
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return result
+	})
+	if _, err := in.Eval("let inc = fn(x) { x + 1 }; let f = fn(x) { callback(x) * 10 };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(1) + f(2)", "50"},
+		{"let g = fn() { nested() + 1 }; g()", "6"},
+		{"[f(1), f(2), callback(7)]", "[20, 30, 8]"},
+	}
+	for _, tt := range tests {
+		result, err := in.Eval(tt.input)
+		if err != nil {
+			t.Fatalf("Eval(%q) failed: %s", tt.input, err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	// an error in the outer run after a nested one has the outer stack
+	_, err := in.Eval("let h = fn() { callback(1) + true }; h()")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+	if runtimeErr.Error() != "1:16: type mismatch: INTEGER + BOOLEAN" ||
+		len(runtimeErr.Stack) != 1 || runtimeErr.Stack[0].String() != "h (1:38)" {
+		t.Errorf("wrong error. got=%q at %v", runtimeErr.Error(), runtimeErr.Stack)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	in := New()
+	_, err := in.Eval("let x = ;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError. got=%T (%v)", err, err)
+	}
+
+	_, err = in.Eval("let y = 1;\ny / 0")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+	if runtimeErr.Error() != "2:1: division by zero" {
+		t.Errorf("wrong error. got=%q", runtimeErr.Error())
+	}
+
+	_, err = in.Eval("let check = fn(x) { if (x < 0) { -true } };\nlet run = fn() { check(-1); 0 };\nrun()")
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+	if len(runtimeErr.Stack) != 2 || runtimeErr.Stack[0].String() != "check (2:18)" ||
+		runtimeErr.Stack[1].String() != "run (3:1)" {
+		t.Errorf("wrong stack. got=%v", runtimeErr.Stack)
+	}
+
+	_, err = in.EvalFile("check.mk", "let f = fn() { 1 / 0 };\nf()")
+	if err == nil || err.Error() != "check.mk:1:16: division by zero" {
+		t.Errorf("wrong error for a file. got=%v", err)
+	}
+	_, err = in.EvalFile("check.mk", "let x = ;")
+	if !errors.As(err, &parseErr) || parseErr.Errors[0].Pos.Filename != "check.mk" {
+		t.Errorf("wrong syntax error for a file. got=%v", err)
+	}
+
+	result, err := in.Eval("let z = 1;")
+	if err != nil || result.Type() != object.NULL_OBJ {
+		t.Errorf("let statement should evaluate to null. got=%v, %v", result, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	in := New()
+	in.SetLimits(evaluator.Limits{MaxSteps: 10000})
+	if _, err := in.Eval("let spin = fn() { while (true) { } };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	_, err := in.Call("spin")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != object.LimitError {
+		t.Fatalf("expected a limit error. got=%v", err)
+	}
+
+	in.SetLimits(evaluator.Limits{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = in.CallContext(ctx, "spin")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != object.LimitError ||
+		runtimeErr.Message != "context deadline exceeded" {
+		t.Fatalf("expected the deadline to stop the script. got=%v", err)
+	}
+
+	in.SetLimits(evaluator.Limits{MaxMemory: 1 << 16})
+	_, err = in.Eval(`let s = "x"; while (true) { s += s }`)
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != object.ResourceError {
+		t.Fatalf("expected a resource error. got=%v", err)
+	}
+
+	_, err = in.Eval("1 + true")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != object.RuntimeError {
+		t.Fatalf("expected a runtime error. got=%v", err)
+	}
+	if result, err := in.Eval("1 + 1"); err != nil || result.Inspect() != "2" {
+		t.Errorf("interpreter unusable after a stopped script. got=%v, %v", result, err)
+	}
+}
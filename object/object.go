@@ -4,22 +4,35 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 
 	"example.com/m/ast"
+	"example.com/m/code"
+	"example.com/m/token"
 )
 
 const (
 	ERROR_OBJ        = "ERROR"
 	INTEGER_OBJ      = "INTEGER"
+	BIGINT_OBJ       = "BIGINT"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+	CELL_OBJ         = "CELL"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 type ObjectType string
@@ -40,6 +53,32 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+// BigInt is an integer out of the range of Integer. Integer arithmetic
+// gives a BigInt when its result overflows an int64, and an Integer
+// whenever the result fits in one, so that each integer has a single
+// representation.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (bi *BigInt) Inspect() string  { return bi.Value.String() }
+func (bi *BigInt) Type() ObjectType { return BIGINT_OBJ }
+
+type Float struct {
+	Value float64
+}
+
+// Inspect keeps a fraction or exponent on integral values, so 2.0 does not
+// print like the integer 2.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 type Boolean struct {
 	Value bool
 }
@@ -59,10 +98,24 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue are the signals of the break and continue statements.
+// Like a ReturnValue they end the enclosing blocks until the loop they
+// belong to handles them.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string // the binding of `let name = fn...`, if any
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -81,6 +134,59 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// CompiledFunction is a function lowered to bytecode by the compiler.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	Positions     code.PositionTable
+	NumLocals     int
+	NumParameters int
+	Name          string
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function together with the free variables it
+// captured when it was created. To scripts it is just a function.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell holds a variable captured by a closure of the vm, so that the
+// closure and the function declaring the variable share its value. While
+// that function runs the cell refers to the variable's slot on the stack;
+// Close moves the value into the cell when the function returns.
+type Cell struct {
+	ref   *Object
+	value Object
+}
+
+// NewCell returns a closed cell holding value.
+func NewCell(value Object) *Cell {
+	c := &Cell{value: value}
+	c.ref = &c.value
+	return c
+}
+
+// NewOpenCell returns a cell referring to the stack slot ref.
+func NewOpenCell(ref *Object) *Cell {
+	return &Cell{ref: ref}
+}
+
+func (c *Cell) Get() Object      { return *c.ref }
+func (c *Cell) Set(value Object) { *c.ref = value }
+func (c *Cell) Close()           { c.value = *c.ref; c.ref = &c.value }
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return fmt.Sprintf("Cell[%p]", c) }
+
 type String struct {
 	Value string
 }
@@ -133,6 +239,32 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a float with an integral value equals that of the integer or
+// big integer, as 1 == 1.0, so h[1] and h[1.0] find the same pair.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+			return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+		}
+		value, _ := big.NewFloat(f.Value).Int(nil)
+		return bigIntHashKey(value)
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
+func (bi *BigInt) HashKey() HashKey {
+	return bigIntHashKey(bi.Value)
+}
+
+func bigIntHashKey(value *big.Int) HashKey {
+	h := fnv.New64a()
+	if value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(value.Bytes())
+	return HashKey{Type: BIGINT_OBJ, Value: h.Sum64()}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -143,15 +275,33 @@ type HashPair struct {
 	Key   Object
 	Value Object
 }
+
+// Hash keeps its pairs in the order their keys were first set. Pairs must
+// only be added with Set, which keeps Keys up to date.
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	Keys  []HashKey // the keys of Pairs, in insertion order
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+// Set binds key to pair. A new key goes after the others, while a key
+// already set keeps its place.
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if _, ok := h.Pairs[key]; !ok {
+		h.Keys = append(h.Keys, key)
+	}
+	h.Pairs[key] = pair
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, key := range h.Keys {
+		pair := h.Pairs[key]
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -161,12 +311,105 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// ErrorKind tells what raised an Error.
+type ErrorKind int
+
+const (
+	// RuntimeError is raised by the program itself, such as a type
+	// mismatch or a call of something that is not a function.
+	RuntimeError ErrorKind = iota
+	// LimitError is raised when the program exceeds a limit set by the
+	// host, such as its step budget, its call depth or the deadline of its
+	// context.
+	LimitError
+	// ResourceError is raised when the program goes over its memory quota.
+	ResourceError
+)
+
+// Catchable reports whether a try statement can catch errors of kind k.
+// Only the errors raised by the program itself can be; a program cannot
+// recover from exceeding the limits set by the host.
+func (k ErrorKind) Catchable() bool {
+	return k == RuntimeError
+}
+
+func (k ErrorKind) String() string {
+	switch k {
+	case RuntimeError:
+		return "runtime error"
+	case LimitError:
+		return "limit error"
+	case ResourceError:
+		return "resource error"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
 type Error struct {
 	Message string
+	Pos     token.Position // where in the source the error was raised
+	Kind    ErrorKind
+	Stack   []StackFrame // the calls active when the error was raised, innermost first
+	Thrown  Object       // the value of the throw statement that raised the error, if any
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// maxTraceLines bounds the frames StackTrace prints.
+const maxTraceLines = 100
+
+// StackTrace formats Stack, one frame per line. A frame repeated more than
+// three times in a row, as by a runaway recursion, is printed once, and the
+// frames past the first maxTraceLines are only counted.
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+	lines := 0
+	for i := 0; i < len(e.Stack); {
+		if lines == maxTraceLines {
+			fmt.Fprintf(&out, "\t... %d more calls\n", len(e.Stack)-i)
+			break
+		}
+		frame := e.Stack[i]
+		n := 1
+		for i+n < len(e.Stack) && e.Stack[i+n] == frame {
+			n++
+		}
+		if n > 3 {
+			out.WriteString("\tat " + frame.String() + "\n")
+			fmt.Fprintf(&out, "\t... repeated %d more times\n", n-1)
+			lines++
+			i += n
+			continue
+		}
+		out.WriteString("\tat " + frame.String() + "\n")
+		lines++
+		i++
+	}
+	return out.String()
+}
+
+// StackFrame is a call of a Monkey function.
+type StackFrame struct {
+	Function string         // the name the function was bound to by let, if any
+	Pos      token.Position // the position of the call, if the call is in the source
+}
+
+func (f StackFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if f.Pos.IsValid() {
+		return name + " (" + f.Pos.String() + ")"
+	}
+	return name
+}
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
@@ -191,6 +434,30 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// Names returns the names bound in e itself, not in the environments
+// enclosing it, in sorted order.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Assign rebinds name in the innermost environment that binds it, walking
+// out through the enclosing environments. It reports false if name is not
+// bound anywhere.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
@@ -1,6 +1,13 @@
 package object
 
-import "testing"
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"example.com/m/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +24,147 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("integral float and integer have different hash keys")
+	}
+	if (&Float{Value: 2.5}).HashKey() != (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 3.5}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+}
+
+func TestBigIntHashKey(t *testing.T) {
+	big1, _ := new(big.Int).SetString("18446744073709551616", 10)
+	big2, _ := new(big.Int).SetString("18446744073709551616", 10)
+	if (&BigInt{Value: big1}).HashKey() != (&BigInt{Value: big2}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+	if (&BigInt{Value: big1}).HashKey() == (&BigInt{Value: new(big.Int).Neg(big1)}).HashKey() {
+		t.Errorf("big integers with different signs have same hash keys")
+	}
+	if (&Float{Value: 18446744073709551616.0}).HashKey() != (&BigInt{Value: big1}).HashKey() {
+		t.Errorf("integral float and big integer have different hash keys")
+	}
+	if (&Float{Value: -18446744073709551616.0}).HashKey() == (&BigInt{Value: big1}).HashKey() {
+		t.Errorf("float and big integer of different signs have same hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := map[float64]string{2: "2.0", 2.5: "2.5", 1e21: "1e+21", -0.125: "-0.125"}
+	for value, expected := range tests {
+		if got := (&Float{Value: value}).Inspect(); got != expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", value, expected, got)
+		}
+	}
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("x", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+
+	if !inner.Assign("x", &Integer{Value: 2}) {
+		t.Fatalf("Assign did not find x in the outer environment")
+	}
+	if x, _ := outer.Get("x"); x.Inspect() != "2" {
+		t.Errorf("outer x not updated. got=%s", x.Inspect())
+	}
+	if _, ok := inner.store["x"]; ok {
+		t.Errorf("Assign bound x in the inner environment")
+	}
+	if inner.Assign("y", &Integer{Value: 3}) {
+		t.Errorf("Assign bound the unbound name y")
+	}
+}
+
+func TestEnvironmentNames(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("z", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("b", &Integer{Value: 2})
+	inner.Set("a", &Integer{Value: 3})
+
+	if names := fmt.Sprint(inner.Names()); names != "[a b]" {
+		t.Errorf("wrong names. want=[a b], got=%s", names)
+	}
+	if names := fmt.Sprint(outer.Names()); names != "[z]" {
+		t.Errorf("wrong names. want=[z], got=%s", names)
+	}
+}
+
+func TestCell(t *testing.T) {
+	slot := Object(&Integer{Value: 1})
+	cell := NewOpenCell(&slot)
+	cell.Set(&Integer{Value: 2})
+	if slot.Inspect() != "2" {
+		t.Errorf("open cell did not write its slot. got=%s", slot.Inspect())
+	}
+	cell.Close()
+	slot = &Integer{Value: 3}
+	if cell.Get().Inspect() != "2" {
+		t.Errorf("closed cell still reads its slot. got=%s", cell.Get().Inspect())
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	str := &String{Value: "abcd"}
+	arr := &Array{Elements: []Object{str, str, &Integer{Value: 1}}}
+	env := NewEnvironment()
+	env.Set("a", arr)
+	env.Set("b", arr)
+	inner := NewEnclosedEnvironment(env)
+	inner.Set("s", str)
+	inner.Set("f", &Function{Env: inner})
+
+	expected := SizeOf(str) + SizeOf(arr)
+	if got := Footprint([]*Environment{inner}, []Object{str}); got != expected {
+		t.Errorf("wrong footprint. want=%d, got=%d", expected, got)
+	}
+	if SizeOf(str) != 20 || SizeOf(arr) != 72 {
+		t.Errorf("wrong sizes. got string=%d, array=%d", SizeOf(str), SizeOf(arr))
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	at := func(name string, line int) StackFrame {
+		return StackFrame{Function: name, Pos: token.Position{Line: line, Column: 1}}
+	}
+	repeat := func(frame StackFrame, n int) []StackFrame {
+		frames := make([]StackFrame, n)
+		for i := range frames {
+			frames[i] = frame
+		}
+		return frames
+	}
+	tests := []struct {
+		stack    []StackFrame
+		expected string
+	}{
+		{nil, ""},
+		{[]StackFrame{at("f", 1), at("", 2)}, "\tat f (1:1)\n\tat <anonymous> (2:1)\n"},
+		{repeat(at("f", 1), 3), "\tat f (1:1)\n\tat f (1:1)\n\tat f (1:1)\n"},
+		{append(repeat(at("f", 1), 10000), at("g", 2)), "\tat f (1:1)\n\t... repeated 9999 more times\n\tat g (2:1)\n"},
+	}
+	for _, tt := range tests {
+		err := &Error{Stack: tt.stack}
+		if got := err.StackTrace(); got != tt.expected {
+			t.Errorf("wrong stack trace. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	// mutual recursion repeats no frame in a row, so only the first
+	// frames are printed
+	var stack []StackFrame
+	for i := 0; i < 1000; i++ {
+		stack = append(stack, at("even", 1), at("odd", 2))
+	}
+	lines := strings.Split((&Error{Stack: stack}).StackTrace(), "\n")
+	if len(lines) != maxTraceLines+2 || lines[maxTraceLines] != "\t... 1900 more calls" {
+		t.Errorf("wrong stack trace of %d lines, ending %q", len(lines), lines[len(lines)-2])
+	}
+}
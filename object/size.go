@@ -0,0 +1,121 @@
+package object
+
+import "sync"
+
+// Estimated sizes in bytes of the values whose memory is accounted for, on
+// a 64-bit platform. They include the Go headers but not allocator overhead.
+const (
+	stringSize  = 16 // plus one byte per byte of the string
+	arraySize   = 24 // plus elementSize per element
+	elementSize = 16
+	hashSize    = 48 // plus pairSize per pair
+	pairSize    = 64
+	bigIntSize  = 32 // plus wordSize per word of the value
+	wordSize    = 8
+)
+
+// SizeOf estimates the memory used by a string, array, hash or big integer
+// itself, not counting the values it holds. It is 0 for other objects.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return stringSize + int64(len(obj.Value))
+	case *Array:
+		return arraySize + elementSize*int64(len(obj.Elements))
+	case *Hash:
+		return hashSize + pairSize*int64(len(obj.Pairs))
+	case *BigInt:
+		return bigIntSize + wordSize*int64(len(obj.Value.Bits()))
+	}
+	return 0
+}
+
+// StringSize estimates the memory of a string of n bytes before it is
+// allocated.
+func StringSize(n int) int64 {
+	return stringSize + int64(n)
+}
+
+// Footprint estimates the memory used by the strings, arrays and hashes
+// reachable from values, envs and the environments enclosing them, counting
+// each value once.
+func Footprint(envs []*Environment, values []Object) int64 {
+	w := walkers.Get().(*footprintWalker)
+	defer w.reset()
+	for _, env := range envs {
+		w.walkEnv(env)
+	}
+	for _, obj := range values {
+		w.walk(obj)
+	}
+	return w.size
+}
+
+// walkers keeps the walkers of finished counts, their maps sized for the
+// values then in use, for the next count to reuse.
+var walkers = sync.Pool{
+	New: func() interface{} {
+		return &footprintWalker{
+			objects: make(map[Object]bool),
+			envs:    make(map[*Environment]bool),
+		}
+	},
+}
+
+type footprintWalker struct {
+	objects map[Object]bool
+	envs    map[*Environment]bool
+	size    int64
+}
+
+// reset empties w, keeping room for as many values, and puts it back in the
+// pool.
+func (w *footprintWalker) reset() {
+	w.objects = make(map[Object]bool, len(w.objects))
+	w.envs = make(map[*Environment]bool, len(w.envs))
+	w.size = 0
+	walkers.Put(w)
+}
+
+func (w *footprintWalker) walkEnv(env *Environment) {
+	for ; env != nil && !w.envs[env]; env = env.outer {
+		w.envs[env] = true
+		for _, obj := range env.store {
+			w.walk(obj)
+		}
+	}
+}
+
+func (w *footprintWalker) walk(obj Object) {
+	switch obj.(type) {
+	case *String, *Array, *Hash, *Function, *Closure, *Cell:
+		if w.objects[obj] {
+			return
+		}
+		w.objects[obj] = true
+	}
+
+	w.size += SizeOf(obj)
+	switch obj := obj.(type) {
+	case *Array:
+		for _, el := range obj.Elements {
+			w.walk(el)
+		}
+	case *Hash:
+		for _, key := range obj.Keys {
+			pair := obj.Pairs[key]
+			w.walk(pair.Key)
+			w.walk(pair.Value)
+		}
+	case *Function:
+		w.walkEnv(obj.Env)
+	case *Closure:
+		for _, cell := range obj.Free {
+			w.walk(cell)
+		}
+	case *Cell:
+		w.walk(obj.Get())
+	case *ReturnValue:
+		w.walk(obj.Value)
+	}
+}
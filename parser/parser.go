@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"runtime"
 	"strconv"
 	"strings"
@@ -14,35 +16,63 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
-	LESSGREATER // > or <
-	SUM         //+
-	PRODUCT     //*
-	PREFIX      //-Xor!X
+	LESSGREATER // > or < or >= or <=
+	SUM         // + or | or ^, as in Go
+	PRODUCT     // * or & or << or >>, as in Go
+	PREFIX      //-Xor!X or ~X
 	CALL        // myFunction(X)
 	INDEX
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOTEQ:    EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOTEQ:           EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LTEQ:            LESSGREATER,
+	token.GTEQ:            LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.BITOR:           SUM,
+	token.BITXOR:          SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.BITAND:          PRODUCT,
+	token.SHL:             PRODUCT,
+	token.SHR:             PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []*Error
+
+	// panicking is set from an error to the end of its statement. The
+	// errors found meanwhile follow from the first one and are dropped.
+	panicking bool
+	// braces counts the braces opened before curToken and not yet closed.
+	braces int
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// loopDepth counts the loops enclosing the current statement within
+	// the current function, so break and continue can be checked.
+	loopDepth int
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -55,19 +85,22 @@ type (
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: make([]string, 0),
+		errors: make([]*Error, 0),
 	}
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BITNOT, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE_HEAD, p.parseTemplateLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
@@ -76,10 +109,25 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.BITAND, p.parseInfixExpression)
+	p.registerInfix(token.BITOR, p.parseInfixExpression)
+	p.registerInfix(token.BITXOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LTEQ, p.parseInfixExpression)
+	p.registerInfix(token.GTEQ, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOTEQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -93,6 +141,15 @@ func New(l *lexer.Lexer) *Parser {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.braces++
+	case token.RBRACE:
+		// a stray brace at the top level closes nothing
+		if p.braces > 0 {
+			p.braces--
+		}
+	}
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -113,6 +170,9 @@ func (p *Parser) ParseProgram() *ast.Program {
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize(0)
+		}
 		p.nextToken()
 	}
 
@@ -125,6 +185,16 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseBranchStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.TRY:
+		return p.parseTryStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -144,6 +214,9 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 	if p.peekTokenTypeIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -174,7 +247,170 @@ func (p *Parser) parseExpressionStatement() ast.Statement {
 	return stmt
 }
 
+// while
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	if p.peekTokenTypeIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// for (init; condition; post)
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	if !p.curTokenTypeIs(token.SEMICOLON) {
+		stmt.Init = p.parseForClause()
+		// the clause consumes its ';' if there is one
+		if !p.curTokenTypeIs(token.SEMICOLON) && !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	if !p.peekTokenTypeIs(token.SEMICOLON) {
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	if !p.peekTokenTypeIs(token.RPAREN) {
+		p.nextToken()
+		stmt.Post = p.parseForClause()
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	if p.peekTokenTypeIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForClause() ast.Statement {
+	if p.curTokenTypeIs(token.LET) {
+		return p.parseLetStatement()
+	}
+	return p.parseExpressionStatement()
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
+// break, continue
+func (p *Parser) parseBranchStatement() ast.Statement {
+	tok := p.curToken
+	if p.loopDepth == 0 {
+		p.errorf(tok.Pos, tok, "%s is not in a loop", tok.Literal).Hint = "break and continue can only be used in while and for loops"
+	}
+	if p.peekTokenTypeIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenTypeIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// try { } catch (e) { } finally { }
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenTypeIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenTypeIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Finally = p.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errorf(p.peekToken.Pos, p.peekToken, "expected catch or finally after try block, got %s instead", p.peekToken.Type).Hint = "a try block is followed by catch (e) { } or finally { }"
+		return nil
+	}
+	if p.peekTokenTypeIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	if p.curTokenTypeIs(token.ILLEGAL) {
+		p.errorf(p.curToken.Pos, p.curToken, "illegal token: %s", p.curToken.Literal)
+		return nil
+	}
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
@@ -201,11 +437,27 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	expr := &ast.IntegerLiteral{Token: p.curToken}
 
-	value, ok := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if ok != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		// todo: use for another function
-		p.errors = append(p.errors, msg)
+	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		p.errorf(p.curToken.Pos, p.curToken, "integer literal %s overflows int64", p.curToken.Literal).Hint =
+			fmt.Sprintf("integers range from %d to %d", int64(math.MinInt64), int64(math.MaxInt64))
+		return nil
+	}
+	if err != nil {
+		p.errorf(p.curToken.Pos, p.curToken, "could not parse %q as integer", p.curToken.Literal).Hint =
+			"the digits of 0x, 0o and 0b integers are hexadecimal, octal and binary, and _ may only go between digits"
+		return nil
+	}
+	expr.Value = value
+	return expr
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	expr := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorf(p.curToken.Pos, p.curToken, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	expr.Value = value
@@ -235,6 +487,48 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// x = 1, a[i] += 2
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		// the error in the target has been reported
+	default:
+		if p.panicking {
+			// so has an error in the target, which may be incomplete
+			break
+		}
+		var err *Error
+		if pos, src, ok := describe(target); ok {
+			err = p.errorf(pos, p.curToken, "cannot assign to %s", src)
+		} else {
+			err = p.errorf(p.curToken.Pos, p.curToken, "cannot assign to the expression before %s", p.curToken.Literal)
+		}
+		err.Hint = "only names and index expressions can be assigned to"
+	}
+	p.nextToken()
+	// assignment is right-associative: a = b = c is a = (b = c)
+	expression.Value = p.parseExpression(ASSIGN - 1)
+	return expression
+}
+
+// describe returns the position and source of node for an error message.
+// A node holding an error that ended its block, as in fn() { x + }, has
+// nil parts that cannot be printed; ok is then false.
+func describe(node ast.Node) (pos token.Position, src string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return node.Pos(), node.String(), true
+}
+
 // parse bool type
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenTypeIs(token.TRUE)}
@@ -286,6 +580,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	braces := p.braces
 	p.nextToken()
 
 	for !p.curTokenTypeIs(token.RBRACE) && !p.curTokenTypeIs(token.EOF) {
@@ -293,8 +588,17 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.panicking {
+			// an error at the closing brace, as in { x + }, ends the block
+			if p.braces < braces {
+				p.panicking = false
+				break
+			}
+			p.synchronize(braces)
+		}
 		p.nextToken()
 	}
+	block.EndToken = p.curToken
 
 	return block
 }
@@ -312,7 +616,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// a loop outside the function cannot be left from inside it
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
 
 	return lit
 }
@@ -342,6 +650,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.EndToken = p.curToken
 	return exp
 }
 
@@ -349,9 +658,34 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// "a ${x} b"
+func (p *Parser) parseTemplateLiteral() ast.Expression {
+	lit := &ast.TemplateLiteral{Token: p.curToken, Strings: []string{p.curToken.Literal}}
+	for {
+		p.nextToken()
+		lit.Expressions = append(lit.Expressions, p.parseExpression(LOWEST))
+		switch p.peekToken.Type {
+		case token.TEMPLATE_MIDDLE:
+			p.nextToken()
+			lit.Strings = append(lit.Strings, p.curToken.Literal)
+		case token.TEMPLATE_TAIL:
+			p.nextToken()
+			lit.Strings = append(lit.Strings, p.curToken.Literal)
+			lit.EndToken = p.curToken
+			return lit
+		default:
+			err := p.errorf(p.peekToken.Pos, p.peekToken, "expected } to end embedded expression, got %s instead", p.peekToken.Type)
+			err.Expected = token.RBRACE
+			err.Hint = "an expression is embedded in a string as ${expression}"
+			return nil
+		}
+	}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.EndToken = p.curToken
 	return array
 }
 
@@ -381,13 +715,14 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.EndToken = p.curToken
 	return exp
 }
 
 // hash
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
+	hash.Pairs = []ast.HashPair{}
 	for !p.peekTokenTypeIs(token.RBRACE) {
 		p.nextToken()
 		key := p.parseExpression(LOWEST)
@@ -396,7 +731,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		}
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 		if !p.peekTokenTypeIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -404,6 +739,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.EndToken = p.curToken
 	return hash
 }
 
@@ -423,8 +759,16 @@ func (p *Parser) curPrecedence() int {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	err := p.errorf(p.curToken.Pos, p.curToken, "no prefix parse function for %s found", t)
+	switch t {
+	case token.SEMICOLON, token.RPAREN, token.RBRACKET, token.RBRACE, token.COMMA, token.EOF:
+		err.Hint = "an expression is missing before " + p.curToken.Literal
+		if t == token.EOF {
+			err.Hint = "an expression is missing at the end of the input"
+		}
+	default:
+		err.Hint = p.curToken.Literal + " cannot start an expression"
+	}
 }
 
 func (p *Parser) curTokenTypeIs(tk token.TokenType) bool {
@@ -444,14 +788,76 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	return false
 }
 
-func (p *Parser) Errors() []string {
+// Errors returns the syntax errors found, in source order. An error is
+// followed by the errors of the next statements, but not by those its
+// statement gives rise to.
+func (p *Parser) Errors() []*Error {
 	return p.errors
 }
 
-// todo: peekError in parser Statment
+// Error is a syntax error.
+type Error struct {
+	Pos      token.Position
+	Msg      string
+	Expected token.TokenType // the token the parser expected, if it expected one
+	Got      token.Token     // the token the parser found instead
+	Hint     string          // how to fix the error, if known
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// hints suggest fixes for a missing token.
+var hints = map[token.TokenType]string{
+	token.RPAREN:    "a ( is not closed",
+	token.RBRACKET:  "a [ is not closed",
+	token.RBRACE:    "a { is not closed",
+	token.IDENT:     "a name is expected here",
+	token.ASSIGN:    "a let statement is written let name = value",
+	token.COLON:     "the pairs of a hash are written key: value",
+	token.LPAREN:    "conditions, parameters and catch clauses are written in parentheses",
+	token.LBRACE:    "bodies are written in braces",
+	token.SEMICOLON: "the clauses of a for loop are separated by ;",
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	err := p.errorf(p.peekToken.Pos, p.peekToken, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
+	err.Expected = t
+	err.Hint = hints[t]
+}
+
+// errorf records an error found at got, unless it follows from an earlier
+// one. It returns the error so that the caller can fill in the rest.
+func (p *Parser) errorf(pos token.Position, got token.Token, format string, a ...interface{}) *Error {
+	err := &Error{Pos: pos, Msg: fmt.Sprintf(format, a...), Got: got}
+	if p.panicking {
+		return err
+	}
+	p.panicking = true
+	p.errors = append(p.errors, err)
+	return err
+}
+
+// synchronize skips the rest of a statement with an error, in a block
+// whose braces are counted by braces: it stops at the semicolon ending the
+// statement or before a let, return or closing brace following it. Braces
+// opened in the statement are skipped along with what they enclose.
+func (p *Parser) synchronize(braces int) {
+loop:
+	for !p.curTokenTypeIs(token.EOF) {
+		if p.braces == braces {
+			if p.curTokenTypeIs(token.SEMICOLON) {
+				break
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.RBRACE:
+				break loop
+			}
+		}
+		p.nextToken()
+	}
+	p.panicking = false
 }
 
 // helper
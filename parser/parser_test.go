@@ -6,6 +6,7 @@ import (
 
 	"example.com/m/ast"
 	"example.com/m/lexer"
+	"example.com/m/token"
 )
 
 func TestLetStatement(t *testing.T) {
@@ -298,6 +299,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b / c",
 			"(a + (b / c))",
 		},
+		{
+			"a + 1 <= b == c >= d * 2",
+			"(((a + 1) <= b) == (c >= (d * 2)))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || !c",
+			"((a && b) || (!c))",
+		},
+		{
+			"x < 1 || x > 5 && f(x)",
+			"((x < 1) || ((x > 5) && f(x)))",
+		},
 		{
 			"a + b * c + d / e - f",
 			"(((a + (b * c)) + (d / e)) - f)",
@@ -310,6 +327,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"5 > 4 == 3 < 4",
 			"((5 > 4) == (3 < 4))",
 		},
+		{
+			"a | b & c ^ d << 2",
+			"((a | (b & c)) ^ (d << 2))",
+		},
+		{
+			"x & mask == 0 || ~x >> 1 + y",
+			"(((x & mask) == 0) || (((~x) >> 1) + y))",
+		},
 		{
 			"5 < 4 != 3 > 4",
 			"((5 < 4) != (3 > 4))",
@@ -378,6 +403,18 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a = b = c || d",
+			"(a = (b = (c || d)))",
+		},
+		{
+			"a[i + 1] += x * 2",
+			"((a[(i + 1)]) += (x * 2))",
+		},
+		{
+			"f(x -= 1, y /= 2)",
+			"f((x -= 1), (y /= 2))",
+		},
 	}
 	for i, tt := range tests {
 		l := lexer.New(tt.input)
@@ -743,16 +780,49 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	if len(hash.Pairs) != 3 {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
-	expected := map[string]int64{"one": 1,
-		"two":   2,
-		"three": 3}
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	expected := []struct {
+		key   string
+		value int64
+	}{{"one", 1}, {"two", 2}, {"three", 3}}
+	for i, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
+			continue
+		}
+		// the pairs are in source order
+		if literal.Value != expected[i].key {
+			t.Errorf("pair %d has wrong key. want=%q, got=%q", i, expected[i].key, literal.Value)
 		}
-		expectedValue := expected[literal.String()]
-		testIntegerLiteral(t, value, expectedValue)
+		testIntegerLiteral(t, pair.Value, expected[i].value)
+	}
+}
+
+func TestTemplateLiteral(t *testing.T) {
+	input := `"Hello ${name}, you have ${len(items)} items"`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	lit, ok := stmt.Expression.(*ast.TemplateLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.TemplateLiteral. got=%T", stmt.Expression)
+	}
+	expectedStrings := []string{"Hello ", ", you have ", " items"}
+	if len(lit.Strings) != len(expectedStrings) || len(lit.Expressions) != 2 {
+		t.Fatalf("wrong parts. got Strings=%q, Expressions=%v", lit.Strings, lit.Expressions)
+	}
+	for i, s := range expectedStrings {
+		if lit.Strings[i] != s {
+			t.Errorf("Strings[%d] wrong. want=%q, got=%q", i, s, lit.Strings[i])
+		}
+	}
+	testIdentifier(t, lit.Expressions[0], "name")
+	if lit.Expressions[1].String() != "len(items)" {
+		t.Errorf("Expressions[1] wrong. got=%q", lit.Expressions[1].String())
+	}
+	if lit.String() != input {
+		t.Errorf("String() wrong. want=%q, got=%q", input, lit.String())
 	}
 }
 
@@ -796,10 +866,10 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			testInfixExpression(t, e, 15, "/", 5)
 		},
 	}
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	for _, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
 			continue
 		}
 		testFunc, ok := tests[literal.String()]
@@ -807,6 +877,270 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			t.Errorf("No test function for key %q found", literal.String())
 			continue
 		}
-		testFunc(value)
+		testFunc(pair.Value)
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"add(1,\n  2;", "2:4: expected next token to be ), got ; instead"},
+		{"let y = ;", "1:9: no prefix parse function for ; found"},
+		{"let z = 1 @ 2;", "1:11: illegal token: @"},
+		{"let c = 1; /* open", "1:12: illegal token: unterminated comment"},
+		{"if (x) { break; }", "1:10: break is not in a loop"},
+		{"while (x) { fn() { continue } }", "1:20: continue is not in a loop"},
+		{"a + b = 1", "1:1: cannot assign to (a + b)"},
+		{"f() *= 2", "1:1: cannot assign to f()"},
+		// an incomplete target is not printed
+		{"(-) = 1", "1:3: no prefix parse function for ) found"},
+		{"(1 + ) = 2", "1:6: no prefix parse function for ) found"},
+		{"fn%0(0)=", "1:3: expected next token to be (, got % instead"},
+		{"(fn() { x + }) = 1", "1:13: no prefix parse function for } found"},
+		{"for (let i = 0 i < 3;) {}", "1:16: expected next token to be ;, got IDENT instead"},
+		{"try { x }", "1:10: expected catch or finally after try block, got EOF instead"},
+		{"try { x } catch { y }", "1:17: expected next token to be (, got { instead"},
+		{"let big = 9223372036854775808;", "1:11: integer literal 9223372036854775808 overflows int64"},
+		{"0xFG", "1:1: could not parse \"0xFG\" as integer"},
+		{"0b102", "1:1: could not parse \"0b102\" as integer"},
+		{"1__000", "1:1: could not parse \"1__000\" as integer"},
+		{`"a ${x y}"`, "1:8: expected } to end embedded expression, got IDENT instead"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// the statement after an error is parsed again
+		{"let x = ;\nlet y 2;\nreturn ]", []string{
+			"1:9: no prefix parse function for ; found",
+			"2:7: expected next token to be =, got INT instead",
+			"3:8: no prefix parse function for ] found",
+		}},
+		// a missing ) does not turn the block into a hash literal
+		{"if (x > 1 { puts(x) }\nlet = 1", []string{
+			"1:11: expected next token to be ), got { instead",
+			"2:5: expected next token to be IDENT, got = instead",
+		}},
+		{"let f = fn(x) { x + };\nf(1, 2", []string{
+			"1:21: no prefix parse function for } found",
+			"2:7: expected next token to be ), got EOF instead",
+		}},
+		{"} let a = 1 let b = ;", []string{
+			"1:1: no prefix parse function for } found",
+			"1:21: no prefix parse function for ; found",
+		}},
+		{"fn() { let a = [1 2]; a }; let b = {1 2}", []string{
+			"1:19: expected next token to be ], got INT instead",
+			"1:39: expected next token to be :, got INT instead",
+		}},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%v", tt.input, len(tt.expected), errors)
+			continue
+		}
+		for i, err := range errors {
+			if err.Error() != tt.expected[i] {
+				t.Errorf("wrong error %d for %q. want=%q, got=%q", i, tt.input, tt.expected[i], err.Error())
+			}
+		}
+	}
+}
+
+func TestParserErrorDetails(t *testing.T) {
+	p := New(lexer.New("add(1, 2;"))
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. want=1, got=%v", errors)
+	}
+	err := errors[0]
+	if err.Expected != token.RPAREN {
+		t.Errorf("wrong Expected. want=%q, got=%q", token.RPAREN, err.Expected)
+	}
+	if err.Got.Type != token.SEMICOLON || err.Got.Pos != err.Pos {
+		t.Errorf("wrong Got. got=%+v", err.Got)
+	}
+	if err.Hint != "a ( is not closed" {
+		t.Errorf("wrong Hint. got=%q", err.Hint)
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2, 3][0])`
+	l := lexer.NewFile("add.mk", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{program, "add.mk:1:1-add.mk:4:18"},
+		{program.Statements[0], "add.mk:1:1-add.mk:3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "add.mk:1:11-add.mk:3:2"},
+		{program.Statements[1], "add.mk:4:1-add.mk:4:18"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression).Arguments[1], "add.mk:4:8-add.mk:4:17"},
+	}
+	for i, tt := range tests {
+		actual := tt.node.Pos().String() + "-" + tt.node.End().String()
+		if actual != tt.expected {
+			t.Errorf("index: %d, expected=%q, got=%q", i, tt.expected, actual)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestCommentsAreIgnored(t *testing.T) {
+	input := `// add two numbers
+let add = fn(a, b) {
+	a /* left */ + b // right
+};`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	expected := "let add = fn(a,b)(a + b);"
+	if program.String() != expected {
+		t.Errorf("expected=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Fatalf("Statements[1] is not ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+}
+
+func TestLoopSemicolon(t *testing.T) {
+	for _, input := range []string{"while (r < 2) { r += 1 }; r", "for (;;) { break }; r"} {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 2 {
+			t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+		}
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (let i = 0; i < 10; let i = i + 1) { continue; }", "for (let i = 0; (i < 10); let i = (i + 1)) continue;"},
+		{"for (;;) { break }", "for (; ; ) break;"},
+		{"for (i; ; f(i)) {}", "for (i; ; f(i)) "},
+		{"for (; ok;) { if (x) { break; } }", "for (; ok; ) ifx break;"},
+		{"for (;;) { break };", "for (; ; ) break;"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if _, ok := program.Statements[0].(*ast.ForStatement); !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T", program.Statements[0])
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { f(); } catch (e) { throw e; }`, "try f() catch (e) throw e;"},
+		{`try { x } finally { done() }`, "try x finally done()"},
+		{`try { x } catch (err) { y } finally { z }`, "try x catch (err) y finally z"},
+		{`throw {"message": "oops"}`, "throw {message:oops};"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
 	}
 }
@@ -0,0 +1,133 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/m/ast"
+	"example.com/m/compiler"
+	"example.com/m/evaluator"
+	"example.com/m/object"
+	"example.com/m/vm"
+)
+
+// Names of the backends accepted by NewBackend.
+const (
+	BackendEval = "eval"
+	BackendVM   = "vm"
+)
+
+// Backend runs parsed programs, keeping global bindings between runs. Errors
+// are returned as *object.Error values, as the evaluator does.
+type Backend interface {
+	Run(program *ast.Program) object.Object
+	// Globals returns the global bindings made by the programs run so far.
+	Globals() map[string]object.Object
+	// SetGlobal binds name to value, as `let` does at the top level.
+	SetGlobal(name string, value object.Object)
+	// Reset drops the global bindings.
+	Reset()
+}
+
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case BackendEval:
+		return newEvalBackend(), nil
+	case BackendVM:
+		return newVMBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q, want %q or %q", name, BackendEval, BackendVM)
+	}
+}
+
+type evalBackend struct {
+	env *object.Environment
+}
+
+func newEvalBackend() *evalBackend {
+	return &evalBackend{env: object.NewEnvironment()}
+}
+
+func (b *evalBackend) Run(program *ast.Program) object.Object {
+	return evaluator.Eval(program, b.env)
+}
+
+func (b *evalBackend) Globals() map[string]object.Object {
+	globals := make(map[string]object.Object)
+	for _, name := range b.env.Names() {
+		globals[name], _ = b.env.Get(name)
+	}
+	return globals
+}
+
+func (b *evalBackend) SetGlobal(name string, value object.Object) {
+	b.env.Set(name, value)
+}
+
+func (b *evalBackend) Reset() {
+	*b = *newEvalBackend()
+}
+
+type vmBackend struct {
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func newVMBackend() *vmBackend {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return &vmBackend{
+		constants:   []object.Object{},
+		globals:     vm.NewGlobalsStore(),
+		symbolTable: symbolTable,
+	}
+}
+
+func (b *vmBackend) Globals() map[string]object.Object {
+	globals := make(map[string]object.Object)
+	for i, name := range b.symbolTable.GlobalNames() {
+		// the variables the compiler makes for itself, such as those of
+		// try statements, have names no program can use
+		if b.globals[i] != nil && !strings.HasPrefix(name, "(") {
+			globals[name] = b.globals[i]
+		}
+	}
+	return globals
+}
+
+func (b *vmBackend) SetGlobal(name string, value object.Object) {
+	b.globals[b.symbolTable.Define(name).Index] = value
+}
+
+func (b *vmBackend) Reset() {
+	*b = *newVMBackend()
+}
+
+func (b *vmBackend) Run(program *ast.Program) object.Object {
+	comp := compiler.NewWithState(b.symbolTable, b.constants)
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	bytecode := comp.Bytecode()
+	b.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalsStore(bytecode, b.globals)
+	if err := machine.Run(); err != nil {
+		return vm.ErrorObject(err)
+	}
+	if machine.Returned() {
+		return machine.LastPoppedStackElem()
+	}
+	// like the evaluator, a program ending in a let statement or a loop
+	// has no value
+	if n := len(program.Statements); n > 0 {
+		switch program.Statements[n-1].(type) {
+		case *ast.ExpressionStatement, *ast.ReturnStatement:
+			return machine.LastPoppedStackElem()
+		}
+	}
+	return nil
+}
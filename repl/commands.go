@@ -0,0 +1,155 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"example.com/m/ast"
+	"example.com/m/lexer"
+	"example.com/m/object"
+	"example.com/m/parser"
+	"example.com/m/token"
+)
+
+type command struct {
+	args string // the arguments, for :help
+	help string
+	run  func(s *session, arg string) (quit bool)
+}
+
+var commands map[string]command
+
+func init() {
+	// set in init, as :help refers to commands
+	commands = map[string]command{
+		":env":    {"", "list the global bindings", (*session).env},
+		":tokens": {"<src>", "print the tokens of src", (*session).tokens},
+		":ast":    {"<src>", "print the syntax tree of src", (*session).ast},
+		":type":   {"<expr>", "run expr and print the type of its value", (*session).typeOf},
+		":load":   {"<file>", "run the program in file", (*session).load},
+		":save":   {"<file>", "write the inputs that ran without error to file", (*session).save},
+		":reset":  {"", "drop the global bindings and the inputs", (*session).reset},
+		":quit":   {"", "leave the REPL", (*session).quit},
+		":help":   {"", "list the commands", (*session).help},
+		CANCEL:    {"", "drop the lines of an incomplete input", func(*session, string) bool { return false }},
+	}
+}
+
+// command runs line, a command and its argument, and reports whether the
+// REPL has to stop.
+func (s *session) command(line string) bool {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i:])
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(s.out, "unknown command %s, see :help\n", name)
+		return false
+	}
+	return cmd.run(s, arg)
+}
+
+func (s *session) env(string) bool {
+	globals := s.backend.Globals()
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.out, "%s = %s\n", name, globals[name].Inspect())
+	}
+	return false
+}
+
+func (s *session) tokens(src string) bool {
+	l := lexer.New(src)
+	for {
+		tk := l.NextToken()
+		fmt.Fprintf(s.out, "%s\t%s\t%q\n", tk.Pos, tk.Type, tk.Literal)
+		if tk.Type == token.EOF {
+			return false
+		}
+	}
+}
+
+func (s *session) ast(src string) bool {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return false
+	}
+	ast.Fprint(s.out, program)
+	return false
+}
+
+func (s *session) typeOf(expr string) bool {
+	p := parser.New(lexer.New(expr))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return false
+	}
+	evaluated := s.backend.Run(program)
+	switch evaluated := evaluated.(type) {
+	case nil:
+		fmt.Fprintln(s.out, object.NULL_OBJ)
+	case *object.Error:
+		fmt.Fprintln(s.out, evaluated.Inspect())
+		return false
+	default:
+		fmt.Fprintln(s.out, evaluated.Type())
+	}
+	// expr ran in the session, so :save has to replay it
+	s.inputs = append(s.inputs, expr)
+	return false
+}
+
+func (s *session) load(file string) bool {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false
+	}
+	s.run(file, string(src))
+	return false
+}
+
+func (s *session) save(file string) bool {
+	var src strings.Builder
+	for _, input := range s.inputs {
+		src.WriteString(input)
+		src.WriteString("\n")
+	}
+	if err := os.WriteFile(file, []byte(src.String()), 0644); err != nil {
+		fmt.Fprintln(s.out, err)
+	}
+	return false
+}
+
+func (s *session) reset(string) bool {
+	s.backend.Reset()
+	s.inputs = nil
+	return false
+}
+
+func (s *session) quit(string) bool {
+	return true
+}
+
+func (s *session) help(string) bool {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(s.out, "%-16s %s\n", strings.TrimSpace(name+" "+cmd.args), cmd.help)
+	}
+	return false
+}
@@ -4,46 +4,138 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strings"
 
-	"example.com/m/evaluator"
 	"example.com/m/lexer"
 	"example.com/m/object"
 	"example.com/m/parser"
+	"example.com/m/token"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT              = ">> "
+	CONTINUATION_PROMPT = ".. "
+)
+
+// CANCEL, typed on a line of its own while an input is incomplete, drops
+// that input.
+const CANCEL = ":cancel"
 
-func Start(in io.Reader, out io.Writer) {
+// Start reads programs from in and runs them on backend, writing results to
+// out. A program may span several lines: while it is incomplete, Start
+// prompts for more. A line starting with a colon is a command; see :help.
+// Start returns at the end of in, dropping an incomplete program.
+func Start(in io.Reader, out io.Writer, backend Backend) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	s := &session{backend: backend, out: out}
+	var lines []string
 
 	for {
-		fmt.Fprintf(out, PROMPT)
+		if len(lines) == 0 {
+			fmt.Fprintf(out, PROMPT)
+		} else {
+			fmt.Fprintf(out, CONTINUATION_PROMPT)
+		}
 
-		scanned := scanner.Scan()
-		if !scanned {
-			continue
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
 		}
 
-		l := lexer.New(scanner.Text())
-		p := parser.New(l)
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		line := scanner.Text()
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if quit := s.command(strings.TrimSpace(line)); quit {
+				return
+			}
+			continue
+		}
+		if len(lines) != 0 && strings.TrimSpace(line) == CANCEL {
+			lines = nil
 			continue
 		}
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		lines = append(lines, line)
+		source := strings.Join(lines, "\n")
+		if incomplete(source) {
+			continue
 		}
+		lines = nil
 
+		s.run("", source)
 	}
 
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// session is the state of the REPL between inputs.
+type session struct {
+	backend Backend
+	out     io.Writer
+	inputs  []string // the inputs that ran without error, for :save
+}
+
+// run runs source, read from filename or typed in if filename is empty, and
+// prints its result. It reports whether source ran without error.
+func (s *session) run(filename, source string) bool {
+	evaluated, ok := Exec(filename, source, s.backend, s.out)
+	if !ok {
+		return false
+	}
+	if evaluated != nil {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+	s.inputs = append(s.inputs, source)
+	return true
+}
+
+// Exec runs source, a whole script, on backend and returns its value.
+// Syntax errors, and the message and stack trace of a runtime error, are
+// written to errOut; Exec reports whether source ran without them. Their
+// positions name filename, the file source was read from, unless it is
+// empty.
+func Exec(filename, source string, backend Backend, errOut io.Writer) (object.Object, bool) {
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(errOut, p.Errors())
+		return nil, false
+	}
+	evaluated := backend.Run(program)
+	if err, ok := evaluated.(*object.Error); ok {
+		io.WriteString(errOut, err.Inspect())
+		io.WriteString(errOut, "\n")
+		io.WriteString(errOut, err.StackTrace())
+		return nil, false
+	}
+	return evaluated, true
+}
+
+// incomplete reports whether source ends inside brackets, a string or a
+// block comment, so that it cannot be parsed before more lines are read.
+func incomplete(source string) bool {
+	l := lexer.New(source)
+	depth := 0
+	for {
+		tk := l.NextToken()
+		switch tk.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET, token.TEMPLATE_HEAD:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET, token.TEMPLATE_TAIL:
+			depth--
+		case token.ILLEGAL:
+			if tk.Literal == "unterminated string" || tk.Literal == "unterminated comment" {
+				return true
+			}
+		case token.EOF:
+			return depth > 0
+		}
+	}
+}
+
+func printParserErrors(out io.Writer, errors []*parser.Error) {
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.Error()+"\n")
+		if err.Hint != "" {
+			io.WriteString(out, "\t  hint: "+err.Hint+"\n")
+		}
 	}
 }
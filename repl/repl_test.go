@@ -0,0 +1,170 @@
+package repl
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/m/object"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let x = 1;", false},
+		{"let add = fn(a, b) {", true},
+		{"let add = fn(a, b) {\n  a + b\n};", false},
+		{"puts(1,", true},
+		{"[1, 2,\n 3", true},
+		{"let s = \"open", true},
+		{"let s = \"a { b\";", false},
+		{"let s = \"a ${f(", true},
+		{"let s = \"a ${x} ${", true},
+		{"let s = \"a ${x} b\";", false},
+		{"/* comment", true},
+		{"// comment {", false},
+		{"1 }", false},
+	}
+	for _, tt := range tests {
+		if got := incomplete(tt.input); got != tt.expected {
+			t.Errorf("incomplete(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	for _, name := range []string{BackendEval, BackendVM} {
+		backend, err := NewBackend(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		// the input ends in an incomplete program, which is dropped
+		Start(strings.NewReader("let x = 1;\nx + 1\n:env\nlet y = [x,\n"), &out, backend)
+
+		expected := ">> >> 2\n>> x = 1\n>> .. \n"
+		if out.String() != expected {
+			t.Errorf("[%s] wrong output. want=%q, got=%q", name, expected, out.String())
+		}
+	}
+}
+
+func TestExec(t *testing.T) {
+	for _, name := range []string{BackendEval, BackendVM} {
+		backend, err := NewBackend(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend.SetGlobal("args", &object.Array{Elements: []object.Object{&object.String{Value: "a"}}})
+
+		var errOut strings.Builder
+		evaluated, ok := Exec("", `args[0] + "b"`, backend, &errOut)
+		if !ok || errOut.Len() != 0 {
+			t.Fatalf("[%s] Exec failed: %s", name, errOut.String())
+		}
+		if str, ok := evaluated.(*object.String); !ok || str.Value != "ab" {
+			t.Errorf("[%s] wrong value. got=%v", name, evaluated)
+		}
+
+		if _, ok := Exec("", "let f = fn(x) { len(x) }; f(1)", backend, &errOut); ok {
+			t.Errorf("[%s] Exec did not fail", name)
+		}
+		expected := "ERROR: 1:17: argument to `len` not supported, got INTEGER\n\tat f (1:27)\n"
+		if errOut.String() != expected {
+			t.Errorf("[%s] wrong error output. want=%q, got=%q", name, expected, errOut.String())
+		}
+
+		// the errors of a script name its file
+		errOut.Reset()
+		Exec("lib.mk", "let g = fn(x) { len(x) };\ng(1)", backend, &errOut)
+		Exec("bad.mk", "let = 1", backend, &errOut)
+		expected = "ERROR: lib.mk:1:17: argument to `len` not supported, got INTEGER\n\tat g (lib.mk:2:1)\n" +
+			"\tbad.mk:1:5: expected next token to be IDENT, got = instead\n\t  hint: a name is expected here\n"
+		if errOut.String() != expected {
+			t.Errorf("[%s] wrong error output. want=%q, got=%q", name, expected, errOut.String())
+		}
+	}
+}
+
+func TestTopLevelReturn(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"return 1; let y = 2", "1"},
+		{"while (true) { return 5 }", "5"},
+		{"let x = 0; try { return 1 } finally { x = 2 }", "1"},
+		{"let f = fn() { 3 }; for (;;) { return f() }", "3"},
+		{"let y = 2", "<nil>"},
+	}
+	for _, name := range []string{BackendEval, BackendVM} {
+		backend, err := NewBackend(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			var errOut strings.Builder
+			evaluated, ok := Exec("", tt.input, backend, &errOut)
+			if !ok {
+				t.Fatalf("[%s] Exec failed: %s", name, errOut.String())
+			}
+			got := "<nil>"
+			if evaluated != nil {
+				got = evaluated.Inspect()
+			}
+			if got != tt.expected {
+				t.Errorf("[%s] wrong value for %q. want=%s, got=%s", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for _, name := range []string{BackendEval, BackendVM} {
+		backend, err := NewBackend(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		s := &session{backend: backend, out: &out}
+		file := filepath.Join(t.TempDir(), "session.mk")
+
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"let x = 5;", ""},
+			{"let s = \"a\" + \"b\";", ""},
+			{":env", "s = ab\nx = 5\n"},
+			{":type x * 2", "INTEGER\n"},
+			{":type x += 1", "INTEGER\n"},
+			{":tokens x + 1", "1:1\tIDENT\t\"x\"\n1:3\t+\t\"+\"\n1:5\tINT\t\"1\"\n1:6\tEOF\t\"\"\n"},
+			{":ast -x", "Program 1:1\n  Statements[0]: ExpressionStatement 1:1\n    Expression: PrefixExpression 1:1\n      Operator: \"-\"\n      Right: Identifier 1:2\n        Value: \"x\"\n"},
+			{"let = 1", "\t1:5: expected next token to be IDENT, got = instead\n\t  hint: a name is expected here\n"},
+			{":save " + file, ""},
+			{":reset", ""},
+			{":env", ""},
+			{":load " + file, "6\n"},
+			{":env", "s = ab\nx = 6\n"},
+			{":nope", "unknown command :nope, see :help\n"},
+		}
+		for _, tt := range tests {
+			out.Reset()
+			if strings.HasPrefix(tt.input, ":") {
+				if s.command(tt.input) {
+					t.Fatalf("[%s] %s quit the REPL", name, tt.input)
+				}
+			} else {
+				s.run("", tt.input)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("[%s] wrong output for %q. want=%q, got=%q", name, tt.input, tt.expected, out.String())
+			}
+		}
+		if !s.command(":quit") {
+			t.Errorf("[%s] :quit did not quit", name)
+		}
+	}
+}
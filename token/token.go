@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
@@ -7,6 +9,7 @@ const (
 	// Identifiers + literals
 	IDENT = "IDENT" // add, foobar, x, y, ...
 	INT   = "INT"
+	FLOAT = "FLOAT" // 3.14, 1e-9
 
 	// Operators
 	ASSIGN   = "="
@@ -15,12 +18,30 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 
 	LT    = "<"
 	GT    = ">"
+	LTEQ  = "<="
+	GTEQ  = ">="
 	EQ    = "=="
 	NOTEQ = "!="
 
+	AND = "&&"
+	OR  = "||"
+
+	BITAND = "&"
+	BITOR  = "|"
+	BITXOR = "^"
+	BITNOT = "~"
+	SHL    = "<<"
+	SHR    = ">>"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -38,9 +59,24 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
 
 	// data type
-	STRING   = "STRING"
+	STRING = "STRING"
+	// the strings of a template literal around the ${...} embedded in it:
+	// "a ${x} b ${y} c" is TEMPLATE_HEAD "a ", the tokens of x,
+	// TEMPLATE_MIDDLE " b ", the tokens of y and TEMPLATE_TAIL " c"
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
 	LBRACKET = "["
 	RBRACKET = "]"
 	COLON    = ":"
@@ -48,19 +84,61 @@ const (
 
 type TokenType string
 
+// Position is a location in the source: an optional file name, the byte
+// offset, and a 1-based line and column.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position has been set by the lexer.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String formats the position as file:line:col, line:col without a file
+// name, or "-" when the position is not valid.
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character
+	End     Position // position immediately after the last character
+
+	// Comments holds the comments between the previous token and this one,
+	// including their delimiters, for tools that need to keep them.
+	Comments []string
 }
 
 var keyWords map[string]TokenType = map[string]TokenType{
-	"let":    LET,
-	"fn":     FUNCTION,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"let":      LET,
+	"fn":       FUNCTION,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
 }
 
 func LookupIdent(ident string) TokenType {
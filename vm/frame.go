@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"example.com/m/code"
+	"example.com/m/object"
+	"example.com/m/token"
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+	pos         token.Position // where the function was called
+	loops       []int          // the height of the stack at each loop being run
+
+	// cells holds the cells of the locals captured by closures; they are
+	// closed when the frame returns.
+	cells map[int]*object.Cell
+}
+
+func NewFrame(cl *object.Closure, basePointer int, pos token.Position) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer, pos: pos}
+}
+
+func (f *Frame) closeCells() {
+	for _, cell := range f.cells {
+		cell.Close()
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// Pos returns the position in the source of the instruction being run.
+func (f *Frame) Pos() token.Position {
+	return f.cl.Fn.Positions.Lookup(f.ip)
+}
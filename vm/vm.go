@@ -0,0 +1,717 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"example.com/m/code"
+	"example.com/m/compiler"
+	"example.com/m/evaluator"
+	"example.com/m/object"
+	"example.com/m/token"
+)
+
+const StackSize = 65536
+const GlobalsSize = 65536
+
+// MaxFrames bounds the nesting of function calls, as
+// evaluator.DefaultMaxDepth does in the evaluator.
+const MaxFrames = evaluator.DefaultMaxDepth
+
+// The vm shares its singletons with the evaluator, so objects can be passed
+// between the two backends and the operator helpers of evaluator apply.
+var (
+	True  = evaluator.TRUE
+	False = evaluator.FALSE
+	Null  = evaluator.NULL
+)
+
+// errStackOverflow stops the vm, without running the handlers of the try
+// statements, as exceeding the call depth stops the evaluator.
+var errStackOverflow = errors.New("stack overflow")
+
+// throwError is the error raised by a throw statement.
+type throwError struct {
+	value object.Object
+}
+
+func (e *throwError) Error() string {
+	return evaluator.ThrownMessage(e.value)
+}
+
+// caughtError is the error caught by the handler of a finally clause, kept
+// so that it is thrown again as it was once the clause has run.
+type caughtError struct {
+	err *RuntimeError
+}
+
+func (ce *caughtError) Type() object.ObjectType { return "CAUGHT_ERROR" }
+func (ce *caughtError) Inspect() string         { return ce.err.Error() }
+
+// RuntimeError is an error raised by the program being run, with where it
+// was raised: the position of the instruction and the calls being run.
+type RuntimeError struct {
+	Err   error
+	Pos   token.Position
+	Stack []object.StackFrame // innermost first
+}
+
+func (e *RuntimeError) Error() string { return e.Err.Error() }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// ErrorObject returns the error object the evaluator would give for err, an
+// error returned by Run.
+func ErrorObject(err error) *object.Error {
+	obj := &object.Error{Message: err.Error()}
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) {
+		obj.Pos, obj.Stack = runtimeErr.Pos, runtimeErr.Stack
+	}
+	var thrown *throwError
+	if errors.As(err, &thrown) {
+		obj.Thrown = thrown.value
+	}
+	if errors.Is(err, errStackOverflow) {
+		obj.Kind = object.LimitError
+	}
+	return obj
+}
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+}
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int // Always points to the next value. Top of stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler // the try statements being run, innermost last
+
+	returned bool // whether a return statement ended the program
+}
+
+// handler is where the vm goes on an error raised by the body of a try
+// statement: the catch address, and the frame, stack and loops the
+// statement started with. The handler of a finally clause gets the error itself
+// rather than the value a catch clause binds.
+type handler struct {
+	catch       int
+	framesIndex int
+	sp          int
+	loops       int
+	finally     bool
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Positions: bytecode.Positions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0, token.Position{})
+
+	// the frames of the calls, and that of the main program
+	frames := make([]*Frame, MaxFrames+1)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.GlobalNames,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore returns a vm that reads and writes the given globals,
+// so that state survives between runs in the REPL.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// NewGlobalsStore allocates a globals store for NewWithGlobalsStore.
+func NewGlobalsStore() []object.Object {
+	return make([]object.Object, GlobalsSize)
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex > MaxFrames {
+		return errStackOverflow
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// LastPoppedStackElem returns the value of the last expression statement,
+// which is the result of the program.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Returned reports whether the program was ended by a return statement,
+// whose value LastPoppedStackElem then returns.
+func (vm *VM) Returned() bool {
+	return vm.returned
+}
+
+// Run executes the bytecode. An error raised by the program is returned as
+// a *RuntimeError. Run never panics: a panic inside the vm or a builtin is
+// returned as an error.
+func (vm *VM) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error: %v", r)
+		}
+	}()
+
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			runtimeErr = &RuntimeError{Err: err, Pos: vm.currentFrame().Pos(), Stack: vm.stackTrace()}
+		}
+		if !vm.catch(runtimeErr) {
+			return runtimeErr
+		}
+	}
+}
+
+// catch hands err to the innermost try statement being run, unwinding the
+// frames and the stack to where the statement started, and reports whether
+// there was one.
+func (vm *VM) catch(err *RuntimeError) bool {
+	if len(vm.handlers) == 0 || err.Err == errStackOverflow {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	var caught object.Object = &caughtError{err: err}
+	if !h.finally {
+		var thrown object.Object
+		if t, ok := err.Err.(*throwError); ok {
+			thrown = t.value
+		}
+		caught = evaluator.CaughtValue(thrown, err.Error(), err.Stack)
+	}
+
+	for vm.framesIndex > h.framesIndex {
+		vm.popFrame().closeCells()
+	}
+	vm.sp = h.sp
+	frame := vm.currentFrame()
+	frame.loops = frame.loops[:h.loops]
+	frame.ip = h.catch - 1
+	return vm.push(caught) == nil
+}
+
+// stackTrace returns the calls being run, innermost first.
+func (vm *VM) stackTrace() []object.StackFrame {
+	var stack []object.StackFrame
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		frame := vm.frames[i]
+		stack = append(stack, object.StackFrame{Function: frame.cl.Fn.Name, Pos: frame.pos})
+	}
+	return stack
+}
+
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpDup2:
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+			code.OpGreaterThan, code.OpLessThan, code.OpGreaterEqual, code.OpLessEqual,
+			code.OpEqual, code.OpNotEqual:
+			right := vm.pop()
+			left := vm.pop()
+			result := evaluator.EvalInfixExpression(infixOperators[op], left, right)
+			if err := vm.pushResult(result); err != nil {
+				return err
+			}
+
+		case code.OpBang:
+			if err := vm.pushResult(evaluator.EvalPrefixExpression("!", vm.pop())); err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			if err := vm.pushResult(evaluator.EvalPrefixExpression("-", vm.pop())); err != nil {
+				return err
+			}
+
+		case code.OpBitNot:
+			if err := vm.pushResult(evaluator.EvalPrefixExpression("~", vm.pop())); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpLoop:
+			frame := vm.currentFrame()
+			frame.loops = append(frame.loops, vm.sp)
+
+		case code.OpUnwindLoop:
+			loops := vm.currentFrame().loops
+			vm.sp = loops[len(loops)-1]
+
+		case code.OpEndLoop:
+			frame := vm.currentFrame()
+			frame.loops = frame.loops[:len(frame.loops)-1]
+
+		case code.OpTry, code.OpTryFinally:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			vm.handlers = append(vm.handlers, handler{
+				catch:       pos,
+				framesIndex: vm.framesIndex,
+				sp:          vm.sp,
+				loops:       len(vm.currentFrame().loops),
+				finally:     op == code.OpTryFinally,
+			})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			value := vm.pop()
+			if caught, ok := value.(*caughtError); ok {
+				return caught.err
+			}
+			return &throwError{value: value}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpAssignGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			if vm.globals[globalIndex] == nil {
+				return fmt.Errorf("identifier not found: %s", vm.globalName(int(globalIndex)))
+			}
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return fmt.Errorf("identifier not found: %s", vm.globalName(int(globalIndex)))
+			}
+			if err := vm.push(global); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			frame := vm.currentFrame()
+			if err := vm.push(vm.stack[frame.basePointer+int(localIndex)]); err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			definition := object.Builtins[builtinIndex]
+			if err := vm.push(definition.Builtin); err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure.Free[freeIndex].Get()); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			currentClosure.Free[freeIndex].Set(vm.pop())
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			if err := vm.push(vm.captureLocal(int(localIndex))); err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			if err := vm.push(vm.currentFrame().cl); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+			if err := vm.push(array); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpTemplate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			var out strings.Builder
+			for _, part := range vm.stack[vm.sp-numParts : vm.sp] {
+				out.WriteString(evaluator.Interpolate(part))
+			}
+			vm.sp = vm.sp - numParts
+			if err := vm.push(&object.String{Value: out.String()}); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(evaluator.EvalIndexExpression(left, index)); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(evaluator.EvalIndexAssignment(left, index, value)); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			if err := vm.executeTailCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				// a return at the top level ends the program; the value
+				// just popped is what LastPoppedStackElem reports.
+				vm.returned = true
+				return nil
+			}
+			frame := vm.popFrame()
+			frame.closeCells()
+			vm.sp = frame.basePointer - 1
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			frame.closeCells()
+			vm.sp = frame.basePointer - 1
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		default:
+			def, err := code.Lookup(byte(op))
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("unhandled opcode %s", def.Name)
+		}
+	}
+	return nil
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return errStackOverflow
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+// pushResult pushes the result of an operator, turning an error object
+// into a runtime error the way the evaluator aborts on it.
+func (vm *VM) pushResult(o object.Object) error {
+	if err, ok := o.(*object.Error); ok {
+		return fmt.Errorf("%s", err.Message)
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) globalName(index int) string {
+	if index < len(vm.globalNames) {
+		return vm.globalNames[index]
+	}
+	return fmt.Sprintf("global %d", index)
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hash := object.NewHash()
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+	}
+	return hash, nil
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs, vm.currentFrame().Pos())
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+// executeTailCall calls a Monkey function in the frame of the caller, which
+// it replaces, so that tail calls take no frames. Anything else is called as
+// by OpCall, and its value returned by the OpReturnValue that follows.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+	if err := checkArity(cl, numArgs); err != nil {
+		return err
+	}
+	// the callee is called from here, in the frame about to go
+	pos := vm.currentFrame().Pos()
+	frame := vm.popFrame()
+	frame.closeCells()
+	// the callee and its arguments take the place of the caller and its
+	// arguments on the stack
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = frame.basePointer + numArgs
+	return vm.callClosure(cl, numArgs, pos)
+}
+
+func checkArity(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+	return nil
+}
+
+// callClosure calls cl from pos with the arguments on top of the stack.
+func (vm *VM) callClosure(cl *object.Closure, numArgs int, pos token.Position) error {
+	if err := checkArity(cl, numArgs); err != nil {
+		return err
+	}
+	frame := NewFrame(cl, vm.sp-numArgs, pos)
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return errStackOverflow
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error in builtin function: %v", r)
+		}
+	}()
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+	if result == nil {
+		return vm.push(Null)
+	}
+	return vm.pushResult(result)
+}
+
+// captureLocal returns the cell of a local of the current frame, so that
+// every closure capturing the local shares it.
+func (vm *VM) captureLocal(index int) *object.Cell {
+	frame := vm.currentFrame()
+	if cell, ok := frame.cells[index]; ok {
+		return cell
+	}
+	if frame.cells == nil {
+		frame.cells = make(map[int]*object.Cell)
+	}
+	cell := object.NewOpenCell(&vm.stack[frame.basePointer+index])
+	frame.cells[index] = cell
+	return cell
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+	free := make([]*object.Cell, numFree)
+	for i := 0; i < numFree; i++ {
+		// a closure capturing the function it is defined in gets a cell
+		// of its own holding that function
+		captured := vm.stack[vm.sp-numFree+i]
+		if cell, ok := captured.(*object.Cell); ok {
+			free[i] = cell
+		} else {
+			free[i] = object.NewCell(captured)
+		}
+	}
+	vm.sp = vm.sp - numFree
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
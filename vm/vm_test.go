@@ -0,0 +1,106 @@
+package vm
+
+import (
+	"testing"
+
+	"example.com/m/compiler"
+	"example.com/m/lexer"
+	"example.com/m/object"
+	"example.com/m/parser"
+)
+
+func testRun(t *testing.T, input string) (object.Object, error) {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+	return vm.LastPoppedStackElem(), nil
+}
+
+func TestRunPrograms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let fib = fn(x) { if (x < 2) { x } else { fib(x - 1) + fib(x - 2) } }; fib(15)", "610"},
+		{"let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } }; let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }; isEven(10)", "true"},
+		{"let newAdder = fn(a, b) { fn(c) { a + b + c } }; newAdder(1, 2)(8)", "11"},
+		{"let f = fn() { let inner = fn(x) { if (x == 0) { 0 } else { inner(x - 1) } }; inner(3) }; f()", "0"},
+		{"return 1; 2", "1"},
+		{"if (true) { }", "null"},
+		{"fn() { }()", "null"},
+		{`let map = fn(arr, f) { if (len(arr) == 0) { [] } else { push(map(rest(arr), f), f(first(arr))) } }; map([1, 2, 3], fn(x) { x * 2 })`, "[6, 4, 2]"},
+		{`puts("")`, "null"},
+	}
+	for _, tt := range tests {
+		result, err := testRun(t, tt.input)
+		if err != nil {
+			t.Errorf("vm error for %q: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(a) { a }()", "wrong number of arguments: want=1, got=0"},
+		{"1()", "not a function: INTEGER"},
+		{"let f = fn() { g }; f()", "identifier not found: g"},
+		{"len(1)", "argument to `len` not supported, got INTEGER"},
+		{"let f = fn() { 1 + f() }; f()", "stack overflow"},
+	}
+	for _, tt := range tests {
+		_, err := testRun(t, tt.input)
+		if err == nil {
+			t.Errorf("expected vm error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong vm error for %q. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	globals := NewGlobalsStore()
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	constants := []object.Object{}
+
+	var result object.Object
+	for _, input := range []string{"let a = 2;", "let b = a * 3;", "a + b"} {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+		machine := NewWithGlobalsStore(bytecode, globals)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		result = machine.LastPoppedStackElem()
+	}
+	if result.Inspect() != "8" {
+		t.Errorf("wrong result. want=8, got=%s", result.Inspect())
+	}
+}